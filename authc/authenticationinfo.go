@@ -2,6 +2,7 @@ package authc
 
 import (
 	"github.com/jalkanen/kuro/authz"
+	"time"
 )
 
 type AuthenticationInfo interface {
@@ -29,8 +30,14 @@ type SimpleAccount struct {
 	credentials interface{}
 	credentialsSalt []byte
 	permissions map[string]authz.Permission
+	permissionSet *authz.PermissionSet
 	roles       map[string]bool
+	roleValidity map[string]authz.Validity
+	attributes  map[string]interface{}
 	Realm string
+
+	// Used by AddPermission() and IsPermitted().  If nil, authz.DefaultPermissionResolver is used.
+	PermissionResolver authz.PermissionResolver
 }
 
 // Just merges the principals from the given info into this one.
@@ -40,13 +47,20 @@ func (a *SimpleAccount) Merge(info AuthenticationInfo) {
 	}
 }
 
+// Returns the roles which are valid right now, according to authz.DefaultClock.
 // TODO: Probably shouldn't iterate through the list the entire time
 func (a *SimpleAccount) Roles() []string {
-	roles := make([]string, len(a.roles))
-	i := 0
+	return a.RolesAt(authz.DefaultClock.Now())
+}
+
+// Implements authz.TimedRoles
+func (a *SimpleAccount) RolesAt(t time.Time) []string {
+	roles := make([]string, 0, len(a.roles))
+
 	for r,_ := range(a.roles) {
-		roles[i] = r
-		i++
+		if a.HasRoleAt(r, t) {
+			roles = append(roles, r)
+		}
 	}
 
 	return roles
@@ -79,6 +93,11 @@ func (a *SimpleAccount) CredentialsSalt() []byte {
 	return a.credentialsSalt
 }
 
+// Sets the salt with which the credentials were hashed.
+func (a *SimpleAccount) SetCredentialsSalt(salt []byte) {
+	a.credentialsSalt = salt
+}
+
 func NewAccount(principal interface{}, credentials interface{}, realm string) *SimpleAccount {
 	s := SimpleAccount{}
 
@@ -91,22 +110,45 @@ func NewAccount(principal interface{}, credentials interface{}, realm string) *S
 
 	s.roles = make(map[string]bool,5)
 	s.permissions = make(map[string]authz.Permission,5)
+	s.permissionSet = authz.NewPermissionSet()
 
 	return &s
 }
 
-func (a *SimpleAccount) AddRole(role string) {
+// Adds a role.  If a Validity is given, the role is only valid within its window.
+func (a *SimpleAccount) AddRole(role string, validity ...authz.Validity) {
 	a.roles[role] = true
+
+	if len(validity) > 0 && !validity[0].IsZero() {
+		if a.roleValidity == nil {
+			a.roleValidity = make(map[string]authz.Validity)
+		}
+		a.roleValidity[role] = validity[0]
+	} else {
+		delete(a.roleValidity, role)
+	}
 }
 
-func (a *SimpleAccount) AddPermissionP(permission authz.Permission) {
+// Adds a permission.  If a Validity is given, the permission is only valid within its window.
+func (a *SimpleAccount) AddPermissionP(permission authz.Permission, validity ...authz.Validity) {
+	if len(validity) > 0 {
+		permission = authz.WithValidity(permission, validity[0])
+	}
+
+	if _, exists := a.permissions[permission.String()]; exists {
+		return
+	}
+
 	a.permissions[permission.String()] = permission
+	a.permissionSet.Add(permission)
 }
 
-func (a *SimpleAccount) AddPermission(permission string) error {
-	p, err := authz.NewWildcardPermission(permission)
+// Adds a permission, resolving it with the PermissionResolver of the account.  The time window
+// may be given either in the string or as a Validity.
+func (a *SimpleAccount) AddPermission(permission string, validity ...authz.Validity) error {
+	p, err := authz.ResolvePermission(a.PermissionResolver, permission)
 	if err == nil {
-		a.AddPermissionP(p)
+		a.AddPermissionP(p, validity...)
 	}
 
 	return err
@@ -116,20 +158,42 @@ func (a *SimpleAccount) AddPermission(permission string) error {
 //  Authorizer interface
 //
 
+// Sets an attribute of the account, which conditional permissions can refer to as "subject.name".
+func (a *SimpleAccount) SetAttribute(name string, value interface{}) {
+	if a.attributes == nil {
+		a.attributes = make(map[string]interface{})
+	}
+
+	a.attributes[name] = value
+}
+
+// Implements authz.SubjectAttributes
+func (a *SimpleAccount) Attributes() map[string]interface{} {
+	return a.attributes
+}
+
+// Returns the compiled permissions of this account, denials included.
+func (a *SimpleAccount) PermissionSet() *authz.PermissionSet {
+	return a.permissionSet
+}
+
 // See if the permissions given to this particular item do imply the
-// given permission
+// given permission, and none of them deny it
 func (a *SimpleAccount) IsPermittedP(permission authz.Permission) bool {
-	for _, p := range a.permissions {
-		if p.Implies(permission) {
-			return true
-		}
+	return a.IsPermittedAt(permission, authz.DefaultClock.Now())
+}
+
+// Like IsPermittedP(), but time-bounded permissions are checked against the given time.
+func (a *SimpleAccount) IsPermittedAt(permission authz.Permission, t time.Time) bool {
+	if a.permissionSet == nil {
+		return false
 	}
 
-	return false
+	return a.permissionSet.ImpliesAt(authz.WithSubjectAttributes(permission, a), t)
 }
 
 func (a *SimpleAccount) IsPermitted(permission string) bool {
-	wp, err := authz.NewWildcardPermission(permission)
+	wp, err := authz.ResolvePermission(a.PermissionResolver, permission)
 
 	if err != nil {
 		return false
@@ -138,6 +202,22 @@ func (a *SimpleAccount) IsPermitted(permission string) bool {
 	return a.IsPermittedP(wp)
 }
 
+// Returns true, if any of the roles or permissions of the account is limited in time.
+func (a *SimpleAccount) TimeBounded() bool {
+	return len(a.roleValidity) > 0 || a.permissionSet.TimeBounded()
+}
+
 func (a *SimpleAccount) HasRole(role string) bool {
-	return a.roles[role]
+	return a.HasRoleAt(role, authz.DefaultClock.Now())
+}
+
+// Returns true, if the account has the role, and it is valid at the given time.
+func (a *SimpleAccount) HasRoleAt(role string, t time.Time) bool {
+	if !a.roles[role] {
+		return false
+	}
+
+	v, timed := a.roleValidity[role]
+
+	return !timed || v.Contains(t)
 }
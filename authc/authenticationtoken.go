@@ -7,6 +7,12 @@ type AuthenticationToken interface {
 	Credentials() interface{}
 }
 
+// Tokens which carry a "remember me" request should implement this.
+type RememberMeAuthenticationToken interface {
+	AuthenticationToken
+	IsRememberMe() bool
+}
+
 type UsernamePasswordToken struct {
 	username   string
 	password   []byte
@@ -42,6 +48,11 @@ func (w *UsernamePasswordToken) Credentials() interface{} {
 	return w.password
 }
 
+// Returns true, if the user wishes their identity to be remembered across sessions.
+func (w *UsernamePasswordToken) IsRememberMe() bool {
+	return w.rememberMe
+}
+
 func (w *UsernamePasswordToken) clear() {
 	w.username = ""
 
@@ -3,7 +3,13 @@ package credential
 import (
 	"github.com/jalkanen/kuro/authc"
 	"hash"
-	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"strings"
 	"io"
 	"bytes"
@@ -28,7 +34,10 @@ type Hashed struct {
 
 // Return a new Hashed credentialsmatcher for the given algorithm and iterations.
 // Salt is provided by the individual AuthenticationInfo if it implements SaltedAuthenticationInfo.
-// Available algorithms are: sha1, sha256, sha384, sha512.
+// Available algorithms are: sha1, sha256, sha384, sha512.  The stored credentials must be
+// the raw hash as a []byte; see ModularCrypt for a format which also carries the parameters.
+//
+// Encode() returns the salt and the hash in the form of EncodeSaltedHash().
 func NewHashed(algorithm string, iterations int32) *Hashed {
 	m := new(Hashed)
 
@@ -38,39 +47,118 @@ func NewHashed(algorithm string, iterations int32) *Hashed {
 	return m
 }
 
+// Returns a new hash for the algorithm, or nil if it is unknown.  Both "sha256" and "SHA-256" work.
 func getHash(algo string) hash.Hash {
-	switch strings.ToLower(algo) {
+	switch strings.Replace(strings.ToLower(algo), "-", "", -1) {
 	case "sha1":
-		return crypto.SHA1.New()
+		return sha1.New()
 	case "sha256":
-		return crypto.SHA256.New()
+		return sha256.New()
 	case "sha384":
-		return crypto.SHA384.New()
+		return sha512.New384()
 	case "sha512":
-		return crypto.SHA512.New()
+		return sha512.New()
 	}
 	return nil
 }
 
+// Returns the credentials as bytes, if they are a string or a []byte.
+func credentialBytes(credentials interface{}) ([]byte, bool) {
+	switch c := credentials.(type) {
+	case string:
+		return []byte(c), true
+	case []byte:
+		return c, true
+	}
+	return nil, false
+}
+
 func (cm *Hashed) Match(token authc.AuthenticationToken, info authc.AuthenticationInfo) bool {
-	hash := getHash(cm.hashAlgorithm)
-	var creds []byte
+	creds, ok := credentialBytes(token.Credentials())
+	stored, isBytes := info.Credentials().([]byte)
 
-	creds = token.Credentials().([]byte)
+	if !ok || !isBytes {
+		return false
+	}
+
+	var salt []byte
 
-	if salt, ok := info.(authc.SaltedAuthenticationInfo); ok {
-		hash.Write(salt.CredentialsSalt())
+	if s, ok := info.(authc.SaltedAuthenticationInfo); ok {
+		salt = s.CredentialsSalt()
 	}
 
+	final := cm.digest(creds, salt)
+
+	return final != nil && subtle.ConstantTimeCompare(final, stored) == 1
+}
+
+// Returns the hash of the password, or nil if the algorithm is unknown.
+func (cm *Hashed) digest(password, salt []byte) []byte {
+	hash := getHash(cm.hashAlgorithm)
+
+	if hash == nil {
+		return nil
+	}
+
+	hash.Write(salt)
+
 	var i int32
 
 	for i = 0; i < cm.hashIterations; i++ {
-		io.Copy(hash, bytes.NewReader(creds) )
+		io.Copy(hash, bytes.NewReader(password) )
+	}
+
+	return hash.Sum(nil)
+}
+
+// Hashes the password with a new salt, and returns both as EncodeSaltedHash() does.
+func (cm *Hashed) Encode(password []byte) (string, error) {
+	salt, err := GenerateSalt()
+
+	if err != nil {
+		return "", err
 	}
 
-	final := hash.Sum(nil)
+	final := cm.digest(password, salt)
 
-	return bytes.Equal(final, info.Credentials().([]byte))
+	if final == nil {
+		return "", ErrUnknownAlgorithm
+	}
+
+	return EncodeSaltedHash(salt, final), nil
+}
+
+// The stored credentials do not tell how they were made, so they never need a rehash.
+func (cm *Hashed) NeedsRehash(credentials interface{}) bool {
+	return false
+}
+
+// Encodes the salt and the hash of a Hashed matcher as "salt$hash", both in base64.  Without
+// a salt, only the hash is encoded.
+func EncodeSaltedHash(salt, hash []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(hash)
+
+	if len(salt) == 0 {
+		return encoded
+	}
+
+	return base64.StdEncoding.EncodeToString(salt) + "$" + encoded
+}
+
+// Decodes the form of EncodeSaltedHash().  The salt is nil if there is none.
+func DecodeSaltedHash(s string) (salt, hash []byte, err error) {
+	if idx := strings.IndexByte(s, '$'); idx >= 0 {
+		if salt, err = base64.StdEncoding.DecodeString(s[:idx]); err != nil {
+			return nil, nil, fmt.Errorf("Invalid salt: %s", err)
+		}
+		s = s[idx+1:]
+	}
+
+	if hash, err = base64.StdEncoding.DecodeString(s); err != nil || len(hash) == 0 {
+		return nil, nil, errors.New("Invalid hash; expected base64, optionally preceded by a base64 salt and '$'")
+	}
+
+	return salt, hash, nil
 }
 
 func max(x, y int32) int32 {
@@ -86,17 +174,23 @@ func NewPlain() *PlainText {
 	return &PlainText{}
 }
 
+// The passwords are compared through their SHA-256 digests, so that the time taken reveals
+// neither the stored password nor its length.
 func (cm *PlainText) Match(token authc.AuthenticationToken, info authc.AuthenticationInfo) bool {
-	var givenPwd []byte
-	// FIXME: Don't ignore errors
-	switch token.Credentials().(type) {
-	case string:
-		givenPwd = []byte(token.Credentials().(string))
-	case []byte:
-		givenPwd = token.Credentials().([]byte)
+	givenPwd, ok := credentialBytes(token.Credentials())
+
+	if !ok {
+		return false
+	}
+
+	storedPwd, ok := info.Credentials().(string)
+
+	if !ok {
+		return false
 	}
 
-	storedPwd, _ := info.Credentials().(string)
+	given := sha256.Sum256(givenPwd)
+	stored := sha256.Sum256([]byte(storedPwd))
 
-	return bytes.Equal( givenPwd, []byte(storedPwd) )
+	return subtle.ConstantTimeCompare(given[:], stored[:]) == 1
 }
@@ -0,0 +1,212 @@
+package credential
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"github.com/jalkanen/kuro/authc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPlainText(t *testing.T) {
+	m := NewPlain()
+	acct := authc.NewAccount("foo", "secret", "test")
+
+	assert.True(t, m.Match(authc.NewToken("foo", "secret"), acct))
+	assert.False(t, m.Match(authc.NewToken("foo", "secre"), acct))
+	assert.False(t, m.Match(authc.NewToken("foo", ""), authc.NewAccount("foo", nil, "test")))
+}
+
+func TestHashed(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	acct := authc.NewAccount("foo", sum[:], "test")
+
+	assert.True(t, NewHashed("sha256", 1).Match(authc.NewToken("foo", "secret"), acct))
+	assert.False(t, NewHashed("sha256", 1).Match(authc.NewToken("foo", "Secret"), acct))
+	assert.False(t, NewHashed("md4", 1).Match(authc.NewToken("foo", "secret"), acct))
+	assert.False(t, NewHashed("sha256", 1).Match(authc.NewToken("foo", "secret"), authc.NewAccount("foo", "secret", "test")))
+
+	m := NewHashed("SHA-512", 5)
+	encoded, err := m.Encode([]byte("secret"))
+	require.NoError(t, err)
+
+	salt, hash, err := DecodeSaltedHash(encoded)
+	require.NoError(t, err)
+	assert.Len(t, salt, DefaultSaltLength)
+	assert.Equal(t, encoded, EncodeSaltedHash(salt, hash))
+
+	acct = authc.NewAccount("foo", hash, "test")
+	assert.False(t, m.Match(authc.NewToken("foo", "secret"), acct), "The salt is needed")
+	acct.SetCredentialsSalt(salt)
+	assert.True(t, m.Match(authc.NewToken("foo", "secret"), acct))
+	assert.False(t, m.NeedsRehash(hash))
+
+	salt, hash, err = DecodeSaltedHash(EncodeSaltedHash(nil, sum[:]))
+	require.NoError(t, err)
+	assert.Nil(t, salt)
+	assert.Equal(t, sum[:], hash)
+
+	for _, s := range []string{"", "!!$AAAA", "AAAA$", "AAAA$!!"} {
+		_, _, err := DecodeSaltedHash(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestShiro1Hash(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+
+	h, err := NewShiro1Hash([]byte("secret"), "SHA-256", 1, salt)
+	require.NoError(t, err)
+
+	want := sha256.Sum256(append(append([]byte{}, salt...), "secret"...))
+	assert.Equal(t, want[:], h.Hash)
+	assert.Equal(t, "$shiro1$SHA-256$1$MDEyMzQ1Njc4OWFiY2RlZg==$"+
+		"xTEOPR5YI+93zjpYBJiKcvxgwwegyJWfNGHiAuSo2BQ=", h.String())
+
+	h, err = NewShiro1Hash([]byte("secret"), "SHA-512", 3, salt)
+	require.NoError(t, err)
+
+	first := sha512.Sum512(append(append([]byte{}, salt...), "secret"...))
+	second := sha512.Sum512(first[:])
+	third := sha512.Sum512(second[:])
+	assert.Equal(t, third[:], h.Hash)
+
+	parsed, err := ParseShiro1Hash(h.String())
+	require.NoError(t, err)
+	assert.Equal(t, h, parsed)
+	assert.True(t, parsed.Verify([]byte("secret")))
+	assert.False(t, parsed.Verify([]byte("secrets")))
+
+	for _, s := range []string{
+		"$shiro2$SHA-256$1$MDEy$bb+Q",
+		"$shiro1$SHA-256$1$MDEy",
+		"$shiro1$MD4$1$MDEy$bb+Q",
+		"$shiro1$SHA-256$0$MDEy$bb+Q",
+		"$shiro1$SHA-256$x$MDEy$bb+Q",
+		"$shiro1$SHA-256$1$!!$bb+Q",
+		"$shiro1$SHA-256$1$MDEy$",
+	} {
+		_, err := ParseShiro1Hash(s)
+		assert.Error(t, err, s)
+	}
+
+	_, err = NewShiro1Hash([]byte("secret"), "SHA-256", 0, salt)
+	assert.Error(t, err)
+}
+
+func TestModularCrypt(t *testing.T) {
+	salt, err := GenerateSalt()
+	require.NoError(t, err)
+	assert.Len(t, salt, DefaultSaltLength)
+
+	sha256Hash, err := NewShiro1Hash([]byte("foopw"), "SHA-256", 1000, salt)
+	require.NoError(t, err)
+	sha512Hash, err := NewShiro1Hash([]byte("barpw"), "SHA-512", 10, nil)
+	require.NoError(t, err)
+
+	foo := authc.NewAccount("foo", sha256Hash.String(), "test")
+	bar := authc.NewAccount("bar", []byte(sha512Hash.String()), "test")
+	legacy := authc.NewAccount("legacy", "plainpw", "test")
+
+	m := NewModularCrypt()
+
+	assert.True(t, m.Match(authc.NewToken("foo", "foopw"), foo))
+	assert.False(t, m.Match(authc.NewToken("foo", "barpw"), foo))
+	assert.True(t, m.Match(authc.NewToken("bar", "barpw"), bar))
+	assert.False(t, m.Match(authc.NewToken("legacy", "plainpw"), legacy), "No fallback")
+	assert.False(t, m.Match(authc.NewToken("foo", "foopw"), authc.NewAccount("foo", "$shiro1$broken", "test")))
+
+	m.Fallback = NewPlain()
+
+	assert.True(t, m.Match(authc.NewToken("legacy", "plainpw"), legacy))
+	assert.True(t, m.Match(authc.NewToken("foo", "foopw"), foo))
+	assert.False(t, m.Match(authc.NewToken("foo", sha256Hash.String()), foo), "The stored hash is not a password")
+}
+
+func TestModularCryptMixed(t *testing.T) {
+	m := NewModularCrypt()
+
+	for _, enc := range []PasswordEncoder{
+		NewShiro1(DefaultShiro1Algorithm, 10),
+		NewPBKDF2(DefaultPBKDF2Algorithm, 10),
+		NewCrypt("SHA-256", MinCryptRounds),
+	} {
+		stored, err := enc.Encode([]byte("foopw"))
+		require.NoError(t, err)
+
+		assert.True(t, m.Match(authc.NewToken("foo", "foopw"), authc.NewAccount("foo", stored, "test")), stored)
+		assert.False(t, m.Match(authc.NewToken("foo", "barpw"), authc.NewAccount("foo", stored, "test")), stored)
+	}
+
+	assert.True(t, m.Match(authc.NewToken("foo", "Hello world!"), authc.NewAccount("foo", cryptVectors[1].hash, "test")))
+
+	_, err := ParsePasswordHash("$2a$10$whatever")
+	assert.Equal(t, ErrUnknownFormat, err)
+}
+
+func TestNeedsRehash(t *testing.T) {
+	shiro, err := NewShiro1("sha256", 10).Encode([]byte("pw"))
+	require.NoError(t, err)
+	pbkdf, err := NewPBKDF2("SHA-512", 10).Encode([]byte("pw"))
+	require.NoError(t, err)
+	crypt, err := NewCrypt("SHA-256", 0).Encode([]byte("pw"))
+	require.NoError(t, err)
+
+	assert.False(t, NewShiro1("SHA-256", 10).NeedsRehash(shiro))
+	assert.True(t, NewShiro1("SHA-256", 20).NeedsRehash(shiro))
+	assert.True(t, NewShiro1("SHA-512", 10).NeedsRehash(shiro))
+	assert.True(t, NewShiro1("SHA-256", 10).NeedsRehash(pbkdf))
+
+	assert.False(t, NewPBKDF2("sha512", 10).NeedsRehash([]byte(pbkdf)))
+	assert.True(t, NewPBKDF2("SHA-256", 10).NeedsRehash(pbkdf))
+	assert.True(t, NewPBKDF2("SHA-512", 10).NeedsRehash("pw"))
+	assert.True(t, NewPBKDF2("SHA-512", 10).NeedsRehash(nil))
+
+	assert.False(t, NewCrypt("SHA-256", DefaultCryptRounds).NeedsRehash(crypt))
+	assert.True(t, NewCrypt("SHA-256", 10000).NeedsRehash(crypt))
+	assert.False(t, NewCrypt("SHA-256", 0).NeedsRehash("$5$rounds=5000$salt$hash"), "The default rounds may be explicit")
+
+	m := NewModularCrypt()
+	assert.False(t, m.NeedsRehash("pw"), "Without an Encoder nothing is rehashed")
+	_, err = m.Encode([]byte("pw"))
+	assert.Equal(t, ErrNoEncoder, err)
+
+	m.Encoder = NewPBKDF2("SHA-512", 10)
+	assert.False(t, m.NeedsRehash(pbkdf))
+	assert.True(t, m.NeedsRehash(shiro))
+	assert.True(t, m.NeedsRehash("pw"))
+}
+
+func TestNewPasswordEncoder(t *testing.T) {
+	e, err := NewPasswordEncoder(FormatShiro1, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, NewShiro1(DefaultShiro1Algorithm, DefaultShiro1Iterations), e)
+
+	e, err = NewPasswordEncoder(FormatPBKDF2, "SHA-512", 10)
+	require.NoError(t, err)
+	assert.Equal(t, NewPBKDF2("SHA-512", 10), e)
+
+	e, err = NewPasswordEncoder(FormatCrypt, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, NewCrypt(DefaultCryptAlgorithm, DefaultCryptRounds), e)
+
+	e, err = NewPasswordEncoder(FormatHashed, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, NewHashed("SHA-256", 1), e)
+
+	for _, args := range []struct {
+		format, algorithm string
+		iterations        int
+	}{
+		{"bcrypt", "", 0},
+		{FormatShiro1, "MD5", 0},
+		{FormatPBKDF2, "SHA-1", 0},
+		{FormatCrypt, "SHA-384", 0},
+		{FormatHashed, "SHA-3", 0},
+		{FormatShiro1, "", -1},
+	} {
+		_, err := NewPasswordEncoder(args.format, args.algorithm, args.iterations)
+		assert.Error(t, err, "%v", args)
+	}
+}
@@ -0,0 +1,83 @@
+package credential
+
+import (
+	"fmt"
+)
+
+// The names of the formats for NewPasswordEncoder.
+const (
+	FormatShiro1 = "shiro1"
+	FormatPBKDF2 = "pbkdf2"
+	FormatCrypt  = "crypt"
+	FormatHashed = "hashed"
+
+	// The algorithm of new crypt(3) hashes, if none is given.
+	DefaultCryptAlgorithm = "SHA-512"
+)
+
+/*
+	Returns the PasswordEncoder for the format, which is one of FormatShiro1, FormatPBKDF2,
+	FormatCrypt and FormatHashed.  An empty algorithm or zero iterations mean the defaults of the
+	format, e.g. DefaultPBKDF2Algorithm and DefaultPBKDF2Iterations.  For crypt(3), the
+	iterations are the rounds.  FormatHashed is a Hashed matcher, which defaults to SHA-256 and
+	a single iteration.
+
+	Returns an error if the format or the algorithm is unknown.
+*/
+func NewPasswordEncoder(format, algorithm string, iterations int) (PasswordEncoder, error) {
+	if iterations < 0 {
+		return nil, fmt.Errorf("Invalid number of iterations: %d", iterations)
+	}
+
+	switch format {
+	case FormatShiro1:
+		algorithm = orDefault(algorithm, DefaultShiro1Algorithm)
+
+		if getHash(algorithm) == nil {
+			return nil, ErrUnknownAlgorithm
+		}
+
+		return NewShiro1(algorithm, orDefaultInt(iterations, DefaultShiro1Iterations)), nil
+	case FormatPBKDF2:
+		algorithm = orDefault(algorithm, DefaultPBKDF2Algorithm)
+
+		if _, err := pbkdf2Name(algorithm); err != nil {
+			return nil, err
+		}
+
+		return NewPBKDF2(algorithm, orDefaultInt(iterations, DefaultPBKDF2Iterations)), nil
+	case FormatCrypt:
+		algorithm = orDefault(algorithm, DefaultCryptAlgorithm)
+
+		if _, err := cryptPrefix(algorithm); err != nil {
+			return nil, err
+		}
+
+		return NewCrypt(algorithm, orDefaultInt(iterations, DefaultCryptRounds)), nil
+	case FormatHashed:
+		algorithm = orDefault(algorithm, DefaultShiro1Algorithm)
+
+		if getHash(algorithm) == nil {
+			return nil, ErrUnknownAlgorithm
+		}
+
+		return NewHashed(algorithm, int32(iterations)), nil
+	}
+
+	return nil, fmt.Errorf("Unknown password hash format '%s'; use %s, %s, %s or %s", format,
+		FormatShiro1, FormatPBKDF2, FormatCrypt, FormatHashed)
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func orDefaultInt(value, def int) int {
+	if value == 0 {
+		return def
+	}
+	return value
+}
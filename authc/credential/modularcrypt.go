@@ -0,0 +1,331 @@
+package credential
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jalkanen/kuro/authc"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Starts a stored credential in the Shiro1 format.
+	Shiro1Prefix = "$shiro1$"
+
+	// The defaults for new Shiro1 hashes, which are also those of Apache Shiro.
+	DefaultShiro1Algorithm  = "SHA-256"
+	DefaultShiro1Iterations = 500000
+
+	// The length of the salts made by GenerateSalt, in bytes.
+	DefaultSaltLength = 16
+)
+
+var (
+	ErrUnknownFormat    = errors.New("Unknown password hash format")
+	ErrUnknownAlgorithm = errors.New("Unknown hash algorithm")
+	ErrNoEncoder        = errors.New("No PasswordEncoder has been set")
+)
+
+// A PasswordHash is a stored password hash which carries its own parameters, such as a
+// Shiro1Hash, a PBKDF2Hash or a CryptHash.  String() returns the stored form.
+type PasswordHash interface {
+	Verify(password []byte) bool
+	String() string
+}
+
+/*
+	A PasswordEncoder is a CredentialsMatcher which can also encode new passwords with its
+	parameters, e.g. when a user changes their password.  Every encoding gets a new salt.
+
+	NeedsRehash() tells whether stored credentials were made with other parameters, such as
+	fewer iterations, and should be encoded again.  The SecurityManager does that on login for
+	realms which are realm.UpdatableCredentialsRealms.
+*/
+type PasswordEncoder interface {
+	CredentialsMatcher
+	Encode(password []byte) (string, error)
+	NeedsRehash(credentials interface{}) bool
+}
+
+// The prefixes of the formats ParsePasswordHash() knows.
+var hashPrefixes = []string{Shiro1Prefix, PBKDF2Prefix, CryptSHA256Prefix, CryptSHA512Prefix}
+
+/*
+	Parses any of the stored forms known to this package: "$shiro1$", "$pbkdf2-sha256$",
+	"$pbkdf2-sha512$", "$5$" and "$6$".  Returns ErrUnknownFormat if the string is in none of them.
+*/
+func ParsePasswordHash(s string) (PasswordHash, error) {
+	// The nil pointers must not become non-nil PasswordHashes, so the errors are checked here
+	switch {
+	case strings.HasPrefix(s, Shiro1Prefix):
+		h, err := ParseShiro1Hash(s)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	case strings.HasPrefix(s, PBKDF2Prefix):
+		h, err := ParsePBKDF2Hash(s)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	case strings.HasPrefix(s, CryptSHA256Prefix), strings.HasPrefix(s, CryptSHA512Prefix):
+		h, err := ParseCryptHash(s)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// Verifies the password in the token against the stored hash in the info.  If prefixes are
+// given, the stored hash must start with one of them.
+func matchHash(token authc.AuthenticationToken, info authc.AuthenticationInfo, prefixes ...string) bool {
+	stored, ok := credentialBytes(info.Credentials())
+
+	if !ok || !hasAnyPrefix(string(stored), prefixes) {
+		return false
+	}
+
+	hash, err := ParsePasswordHash(string(stored))
+
+	if err != nil {
+		return false
+	}
+
+	password, ok := credentialBytes(token.Credentials())
+
+	return ok && hash.Verify(password)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+
+	return len(prefixes) == 0
+}
+
+/*
+	A Shiro1Hash is a salted, iterated password hash which carries everything needed to verify
+	it.  Its string form is the modular crypt format of Apache Shiro, so hashes can be shared
+	with Shiro applications:
+
+		$shiro1$SHA-256$500000$<base64 salt>$<base64 hash>
+
+	The hash is digest(salt + password), digested again until there have been Iterations
+	rounds in all.  The algorithms are SHA-1, SHA-256, SHA-384 and SHA-512.
+*/
+type Shiro1Hash struct {
+	Algorithm  string
+	Iterations int
+	Salt       []byte
+	Hash       []byte
+}
+
+// Hashes the password.  Use GenerateSalt() for the salt.
+func NewShiro1Hash(password []byte, algorithm string, iterations int, salt []byte) (*Shiro1Hash, error) {
+	if iterations < 1 {
+		return nil, fmt.Errorf("Invalid number of iterations: %d", iterations)
+	}
+
+	hash, err := shiro1Digest(password, algorithm, iterations, salt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Shiro1Hash{
+		Algorithm:  algorithm,
+		Iterations: iterations,
+		Salt:       salt,
+		Hash:       hash,
+	}, nil
+}
+
+// Parses the string form of a Shiro1Hash.
+func ParseShiro1Hash(s string) (*Shiro1Hash, error) {
+	if !strings.HasPrefix(s, Shiro1Prefix) {
+		return nil, ErrUnknownFormat
+	}
+
+	fields := strings.Split(s[len(Shiro1Prefix):], "$")
+
+	if len(fields) != 4 {
+		return nil, errors.New("Malformed Shiro1 hash; expected $shiro1$algorithm$iterations$salt$hash")
+	}
+
+	if getHash(fields[0]) == nil {
+		return nil, ErrUnknownAlgorithm
+	}
+
+	iterations, err := strconv.Atoi(fields[1])
+
+	if err != nil || iterations < 1 {
+		return nil, fmt.Errorf("Invalid number of iterations in Shiro1 hash: '%s'", fields[1])
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(fields[2])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid salt in Shiro1 hash: %s", err)
+	}
+
+	hash, err := base64.StdEncoding.DecodeString(fields[3])
+
+	if err != nil || len(hash) == 0 {
+		return nil, errors.New("Invalid hash in Shiro1 hash")
+	}
+
+	return &Shiro1Hash{
+		Algorithm:  fields[0],
+		Iterations: iterations,
+		Salt:       salt,
+		Hash:       hash,
+	}, nil
+}
+
+// Returns true, if the password hashes to the same value.  The comparison takes constant time.
+func (h *Shiro1Hash) Verify(password []byte) bool {
+	hash, err := shiro1Digest(password, h.Algorithm, h.Iterations, h.Salt)
+
+	return err == nil && subtle.ConstantTimeCompare(hash, h.Hash) == 1
+}
+
+func (h *Shiro1Hash) String() string {
+	return Shiro1Prefix + h.Algorithm + "$" + strconv.Itoa(h.Iterations) + "$" +
+		base64.StdEncoding.EncodeToString(h.Salt) + "$" +
+		base64.StdEncoding.EncodeToString(h.Hash)
+}
+
+func shiro1Digest(password []byte, algorithm string, iterations int, salt []byte) ([]byte, error) {
+	hash := getHash(algorithm)
+
+	if hash == nil {
+		return nil, ErrUnknownAlgorithm
+	}
+
+	hash.Write(salt)
+	hash.Write(password)
+	sum := hash.Sum(nil)
+
+	for i := 1; i < iterations; i++ {
+		hash.Reset()
+		hash.Write(sum)
+		sum = hash.Sum(sum[:0])
+	}
+
+	return sum, nil
+}
+
+// A PasswordEncoder for Shiro1Hashes.  It only matches stored credentials in the Shiro1 format,
+// but their own parameters are used; see ModularCrypt for matching several formats.
+type Shiro1 struct {
+	Algorithm  string
+	Iterations int
+}
+
+// Returns a new Shiro1 matcher, which encodes with the given algorithm and iterations.
+func NewShiro1(algorithm string, iterations int) *Shiro1 {
+	return &Shiro1{Algorithm: algorithm, Iterations: iterations}
+}
+
+func (cm *Shiro1) Match(token authc.AuthenticationToken, info authc.AuthenticationInfo) bool {
+	return matchHash(token, info, Shiro1Prefix)
+}
+
+func (cm *Shiro1) Encode(password []byte) (string, error) {
+	salt, err := GenerateSalt()
+
+	if err != nil {
+		return "", err
+	}
+
+	h, err := NewShiro1Hash(password, cm.Algorithm, cm.Iterations, salt)
+
+	if err != nil {
+		return "", err
+	}
+
+	return h.String(), nil
+}
+
+// Returns true, unless the credentials are a Shiro1Hash with the same algorithm and iterations.
+func (cm *Shiro1) NeedsRehash(credentials interface{}) bool {
+	stored, _ := credentialBytes(credentials)
+	h, err := ParseShiro1Hash(string(stored))
+
+	return err != nil || !sameAlgorithm(h.Algorithm, cm.Algorithm) || h.Iterations != cm.Iterations
+}
+
+// Returns true, if the names refer to the same algorithm, e.g. "sha256" and "SHA-256".
+func sameAlgorithm(a, b string) bool {
+	return strings.Replace(strings.ToLower(a), "-", "", -1) == strings.Replace(strings.ToLower(b), "-", "", -1)
+}
+
+// Returns a new random salt of DefaultSaltLength bytes.
+func GenerateSalt() ([]byte, error) {
+	salt := make([]byte, DefaultSaltLength)
+
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return salt, nil
+}
+
+/*
+	A CredentialsMatcher for stored credentials in any of the self-describing formats of
+	ParsePasswordHash(), such as "$shiro1$SHA-256$500000$..." or "$6$...".  Each stored
+	credential carries its own algorithm, iterations and salt, so accounts hashed in different
+	ways can live in the same realm, e.g. while moving to a stronger algorithm.
+
+	Stored credentials which are in no known format are given to Fallback, if it is set, and
+	fail otherwise.  Setting Fallback to a PlainText matcher allows migrating away from
+	plaintext passwords one account at a time.
+
+	New passwords are encoded with Encoder, and stored credentials which Encoder would not have
+	made need a rehash.  Without an Encoder, nothing is ever rehashed.
+*/
+type ModularCrypt struct {
+	Fallback CredentialsMatcher
+	Encoder  PasswordEncoder
+}
+
+// Returns a new ModularCrypt matcher without a fallback.
+func NewModularCrypt() *ModularCrypt {
+	return &ModularCrypt{}
+}
+
+func (cm *ModularCrypt) Match(token authc.AuthenticationToken, info authc.AuthenticationInfo) bool {
+	stored, ok := credentialBytes(info.Credentials())
+
+	if !ok {
+		return false
+	}
+
+	if !hasAnyPrefix(string(stored), hashPrefixes) {
+		return cm.Fallback != nil && cm.Fallback.Match(token, info)
+	}
+
+	return matchHash(token, info)
+}
+
+func (cm *ModularCrypt) Encode(password []byte) (string, error) {
+	if cm.Encoder == nil {
+		return "", ErrNoEncoder
+	}
+
+	return cm.Encoder.Encode(password)
+}
+
+func (cm *ModularCrypt) NeedsRehash(credentials interface{}) bool {
+	return cm.Encoder != nil && cm.Encoder.NeedsRehash(credentials)
+}
@@ -0,0 +1,178 @@
+package credential
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jalkanen/kuro/authc"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Starts a stored credential in the PBKDF2 format.
+	PBKDF2Prefix = "$pbkdf2-"
+
+	// The defaults for new PBKDF2 hashes, as recommended by OWASP.
+	DefaultPBKDF2Algorithm  = "SHA-256"
+	DefaultPBKDF2Iterations = 600000
+)
+
+// The base64 variant of passlib: no padding, and "." instead of "+".
+var adaptedBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+/*
+	A PBKDF2Hash is a password hash made with PBKDF2-HMAC-SHA256 or PBKDF2-HMAC-SHA512.  The
+	hash is as long as the output of the hash function.  Its string form is that of Python's
+	passlib, with the salt and the hash in base64 without padding and with "." for "+":
+
+		$pbkdf2-sha256$600000$<salt>$<hash>
+*/
+type PBKDF2Hash struct {
+	Algorithm  string
+	Iterations int
+	Salt       []byte
+	Hash       []byte
+}
+
+// Hashes the password.  The algorithm is either SHA-256 or SHA-512.
+func NewPBKDF2Hash(password []byte, algorithm string, iterations int, salt []byte) (*PBKDF2Hash, error) {
+	if iterations < 1 {
+		return nil, fmt.Errorf("Invalid number of iterations: %d", iterations)
+	}
+
+	name, err := pbkdf2Name(algorithm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := &PBKDF2Hash{
+		Algorithm:  algorithm,
+		Iterations: iterations,
+		Salt:       salt,
+	}
+
+	h.Hash = h.derive(password, getHash(name).Size())
+
+	return h, nil
+}
+
+// Parses the string form of a PBKDF2Hash.
+func ParsePBKDF2Hash(s string) (*PBKDF2Hash, error) {
+	if !strings.HasPrefix(s, PBKDF2Prefix) {
+		return nil, ErrUnknownFormat
+	}
+
+	fields := strings.Split(s[len(PBKDF2Prefix):], "$")
+
+	if len(fields) != 4 {
+		return nil, errors.New("Malformed PBKDF2 hash; expected $pbkdf2-algorithm$iterations$salt$hash")
+	}
+
+	if _, err := pbkdf2Name(fields[0]); err != nil {
+		return nil, err
+	}
+
+	iterations, err := strconv.Atoi(fields[1])
+
+	if err != nil || iterations < 1 {
+		return nil, fmt.Errorf("Invalid number of iterations in PBKDF2 hash: '%s'", fields[1])
+	}
+
+	salt, err := adaptedBase64.DecodeString(fields[2])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid salt in PBKDF2 hash: %s", err)
+	}
+
+	hash, err := adaptedBase64.DecodeString(fields[3])
+
+	if err != nil || len(hash) == 0 {
+		return nil, errors.New("Invalid hash in PBKDF2 hash")
+	}
+
+	return &PBKDF2Hash{
+		Algorithm:  fields[0],
+		Iterations: iterations,
+		Salt:       salt,
+		Hash:       hash,
+	}, nil
+}
+
+// Returns true, if the password hashes to the same value.  The comparison takes constant time.
+func (h *PBKDF2Hash) Verify(password []byte) bool {
+	if _, err := pbkdf2Name(h.Algorithm); err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(h.derive(password, len(h.Hash)), h.Hash) == 1
+}
+
+func (h *PBKDF2Hash) String() string {
+	name, _ := pbkdf2Name(h.Algorithm)
+
+	return PBKDF2Prefix + name + "$" + strconv.Itoa(h.Iterations) + "$" +
+		adaptedBase64.EncodeToString(h.Salt) + "$" +
+		adaptedBase64.EncodeToString(h.Hash)
+}
+
+func (h *PBKDF2Hash) derive(password []byte, length int) []byte {
+	name, _ := pbkdf2Name(h.Algorithm)
+
+	return pbkdf2.Key(password, h.Salt, h.Iterations, length, func() hash.Hash { return getHash(name) })
+}
+
+// Returns the name of the algorithm as it appears in the string form, i.e. "sha256" or "sha512".
+func pbkdf2Name(algorithm string) (string, error) {
+	name := strings.Replace(strings.ToLower(algorithm), "-", "", -1)
+
+	if name != "sha256" && name != "sha512" {
+		return "", ErrUnknownAlgorithm
+	}
+
+	return name, nil
+}
+
+// A PasswordEncoder for PBKDF2Hashes.  It only matches stored credentials in the PBKDF2 format,
+// but their own parameters are used; see ModularCrypt for matching several formats.
+type PBKDF2 struct {
+	Algorithm  string
+	Iterations int
+}
+
+// Returns a new PBKDF2 matcher, which encodes with the given algorithm and iterations.
+func NewPBKDF2(algorithm string, iterations int) *PBKDF2 {
+	return &PBKDF2{Algorithm: algorithm, Iterations: iterations}
+}
+
+func (cm *PBKDF2) Match(token authc.AuthenticationToken, info authc.AuthenticationInfo) bool {
+	return matchHash(token, info, PBKDF2Prefix)
+}
+
+func (cm *PBKDF2) Encode(password []byte) (string, error) {
+	salt, err := GenerateSalt()
+
+	if err != nil {
+		return "", err
+	}
+
+	h, err := NewPBKDF2Hash(password, cm.Algorithm, cm.Iterations, salt)
+
+	if err != nil {
+		return "", err
+	}
+
+	return h.String(), nil
+}
+
+// Returns true, unless the credentials are a PBKDF2Hash with the same algorithm and iterations.
+func (cm *PBKDF2) NeedsRehash(credentials interface{}) bool {
+	stored, _ := credentialBytes(credentials)
+	h, err := ParsePBKDF2Hash(string(stored))
+
+	return err != nil || !sameAlgorithm(h.Algorithm, cm.Algorithm) || h.Iterations != cm.Iterations
+}
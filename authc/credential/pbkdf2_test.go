@@ -0,0 +1,60 @@
+package credential
+
+import (
+	"github.com/jalkanen/kuro/authc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPBKDF2Hash(t *testing.T) {
+	salt := []byte("saltsaltsaltsalt")
+
+	h, err := NewPBKDF2Hash([]byte("password"), "SHA-256", 1000, salt)
+	require.NoError(t, err)
+	assert.Equal(t, "$pbkdf2-sha256$1000$c2FsdHNhbHRzYWx0c2FsdA$8nX7hwFEzIB8aPajJTYK8weHQc5Ngz0pFVAKvSu4jQA", h.String())
+
+	h, err = NewPBKDF2Hash([]byte("password"), "sha512", 1000, salt)
+	require.NoError(t, err)
+	assert.Equal(t, "$pbkdf2-sha512$1000$c2FsdHNhbHRzYWx0c2FsdA$"+
+		"715rqIr5dXOVPpBhqqsugl037zT5bWJTWYmZtIcK8hBnisKpwfY7kokvwjDrNHqHhF50Pb7MD6HvkJwiDQw4ww", h.String())
+
+	parsed, err := ParsePBKDF2Hash(h.String())
+	require.NoError(t, err)
+	assert.Equal(t, h.String(), parsed.String())
+	assert.True(t, parsed.Verify([]byte("password")))
+	assert.False(t, parsed.Verify([]byte("Password")))
+
+	for _, s := range []string{
+		"$pbkdf2-sha1$1000$c2Fsd$8nX7",
+		"$pbkdf2-sha256$0$c2Fsd$8nX7",
+		"$pbkdf2-sha256$1000$c2Fsd",
+		"$pbkdf2-sha256$1000$c2Fsd+$8nX7",
+		"$pbkdf2-sha256$1000$c2Fsd$",
+	} {
+		_, err := ParsePBKDF2Hash(s)
+		assert.Error(t, err, s)
+	}
+
+	_, err = NewPBKDF2Hash([]byte("password"), "SHA-1", 1000, salt)
+	assert.Equal(t, ErrUnknownAlgorithm, err)
+}
+
+func TestPBKDF2Matcher(t *testing.T) {
+	var m PasswordEncoder = NewPBKDF2("SHA-512", 1000)
+
+	stored, err := m.Encode([]byte("foopw"))
+	require.NoError(t, err)
+
+	parsed, err := ParsePasswordHash(stored)
+	require.NoError(t, err)
+	require.IsType(t, &PBKDF2Hash{}, parsed)
+	assert.Equal(t, 1000, parsed.(*PBKDF2Hash).Iterations)
+
+	assert.True(t, m.Match(authc.NewToken("foo", "foopw"), authc.NewAccount("foo", stored, "test")))
+	assert.False(t, m.Match(authc.NewToken("foo", "barpw"), authc.NewAccount("foo", stored, "test")))
+
+	again, err := m.Encode([]byte("foopw"))
+	require.NoError(t, err)
+	assert.NotEqual(t, stored, again, "Every encoding must get a new salt")
+}
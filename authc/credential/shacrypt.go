@@ -0,0 +1,347 @@
+package credential
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/jalkanen/kuro/authc"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Start stored credentials in the crypt(3) SHA-256 and SHA-512 formats.
+	CryptSHA256Prefix = "$5$"
+	CryptSHA512Prefix = "$6$"
+
+	// The rounds crypt(3) uses when a hash does not say, and the limits it keeps the rounds in.
+	DefaultCryptRounds = 5000
+	MinCryptRounds     = 1000
+	MaxCryptRounds     = 999999999
+
+	cryptRoundsPrefix = "rounds="
+	cryptMaxSalt      = 16
+	cryptAlphabet     = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// The order in which crypt(3) encodes the bytes of the final digest, three at a time.
+var (
+	cryptSHA256Order = []int{
+		0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14,
+		15, 25, 5, 6, 16, 26, 27, 7, 17, 18, 28, 8, 9, 19, 29,
+	}
+	cryptSHA512Order = []int{
+		0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4,
+		47, 5, 26, 6, 27, 48, 28, 49, 7, 50, 8, 29, 9, 30, 51,
+		31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55, 13, 56, 14, 35,
+		15, 36, 57, 37, 58, 16, 59, 17, 38, 18, 39, 60, 40, 61, 19,
+		62, 20, 41,
+	}
+)
+
+/*
+	A CryptHash is a password hash in the SHA-256 or SHA-512 format of glibc crypt(3), as found
+	in /etc/shadow:
+
+		$5$rounds=10000$<salt>$<hash>
+		$6$<salt>$<hash>
+
+	If there is no "rounds=", DefaultCryptRounds are used.  The salt is at most 16 characters.
+*/
+type CryptHash struct {
+	// Either "SHA-256" or "SHA-512"
+	Algorithm string
+
+	// Zero means DefaultCryptRounds without "rounds=" in the string form.  Explicit rounds,
+	// even DefaultCryptRounds, are written out, so parsed hashes keep their string form.
+	Rounds int
+
+	Salt string
+	Hash string
+}
+
+// Hashes the password.  Use GenerateCryptSalt() for the salt.  Rounds outside the limits are
+// brought within them, as crypt(3) does.
+func NewCryptHash(password []byte, algorithm string, rounds int, salt string) (*CryptHash, error) {
+	if _, err := cryptPrefix(algorithm); err != nil {
+		return nil, err
+	}
+
+	if len(salt) > cryptMaxSalt {
+		salt = salt[:cryptMaxSalt]
+	}
+
+	if strings.ContainsAny(salt, "$\n") {
+		return nil, errors.New("Crypt salt must not contain '$' or newlines")
+	}
+
+	h := &CryptHash{
+		Algorithm: algorithm,
+		Rounds:    clampRounds(rounds),
+		Salt:      salt,
+	}
+
+	h.Hash = h.digest(password)
+
+	return h, nil
+}
+
+// Parses the string form of a CryptHash.
+func ParseCryptHash(s string) (*CryptHash, error) {
+	var h CryptHash
+
+	switch {
+	case strings.HasPrefix(s, CryptSHA256Prefix):
+		h.Algorithm = "SHA-256"
+	case strings.HasPrefix(s, CryptSHA512Prefix):
+		h.Algorithm = "SHA-512"
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	fields := strings.Split(s[len(CryptSHA256Prefix):], "$")
+
+	if len(fields) == 3 && strings.HasPrefix(fields[0], cryptRoundsPrefix) {
+		rounds, err := strconv.Atoi(fields[0][len(cryptRoundsPrefix):])
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid rounds in crypt hash: '%s'", fields[0])
+		}
+
+		h.Rounds = clampRounds(rounds)
+		fields = fields[1:]
+	}
+
+	if len(fields) != 2 || len(fields[0]) > cryptMaxSalt || fields[1] == "" {
+		return nil, errors.New("Malformed crypt hash; expected $5$ or $6$, optional rounds=N$, salt$hash")
+	}
+
+	h.Salt, h.Hash = fields[0], fields[1]
+
+	return &h, nil
+}
+
+// Returns true, if the password hashes to the same value.  The comparison takes constant time.
+func (h *CryptHash) Verify(password []byte) bool {
+	if _, err := cryptPrefix(h.Algorithm); err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(h.digest(password)), []byte(h.Hash)) == 1
+}
+
+func (h *CryptHash) String() string {
+	prefix, _ := cryptPrefix(h.Algorithm)
+
+	if h.Rounds != 0 {
+		prefix += cryptRoundsPrefix + strconv.Itoa(h.Rounds) + "$"
+	}
+
+	return prefix + h.Salt + "$" + h.Hash
+}
+
+// Runs the algorithm of crypt(3), and returns the encoded digest.
+func (h *CryptHash) digest(password []byte) string {
+	var newHash func() hash.Hash
+	var order []int
+
+	if prefix, _ := cryptPrefix(h.Algorithm); prefix == CryptSHA256Prefix {
+		newHash, order = func() hash.Hash { return getHash("sha256") }, cryptSHA256Order
+	} else {
+		newHash, order = func() hash.Hash { return getHash("sha512") }, cryptSHA512Order
+	}
+
+	rounds := effectiveRounds(h.Rounds)
+
+	salt := []byte(h.Salt)
+
+	b := newHash()
+	b.Write(password)
+	b.Write(salt)
+	b.Write(password)
+	digestB := b.Sum(nil)
+	size := len(digestB)
+
+	a := newHash()
+	a.Write(password)
+	a.Write(salt)
+	a.Write(repeat(digestB, len(password)))
+
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(password)
+		}
+	}
+
+	digestA := a.Sum(nil)
+
+	dp := newHash()
+	for i := 0; i < len(password); i++ {
+		dp.Write(password)
+	}
+	p := repeat(dp.Sum(nil), len(password))
+
+	ds := newHash()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeat(ds.Sum(nil), len(salt))
+
+	c := digestA
+
+	for i := 0; i < rounds; i++ {
+		r := newHash()
+
+		if i&1 != 0 {
+			r.Write(p)
+		} else {
+			r.Write(c)
+		}
+
+		if i%3 != 0 {
+			r.Write(s)
+		}
+
+		if i%7 != 0 {
+			r.Write(p)
+		}
+
+		if i&1 != 0 {
+			r.Write(c)
+		} else {
+			r.Write(p)
+		}
+
+		c = r.Sum(c[:0])
+	}
+
+	return encodeCrypt(c[:size], order)
+}
+
+// Returns the bytes repeated up to the given length.
+func repeat(b []byte, length int) []byte {
+	res := make([]byte, length)
+
+	for i := range res {
+		res[i] = b[i%len(b)]
+	}
+
+	return res
+}
+
+// Encodes the digest with the base64 alphabet of crypt(3), in the given byte order.  The
+// bytes which do not fill a group of three are encoded last.
+func encodeCrypt(digest []byte, order []int) string {
+	var b strings.Builder
+
+	emit := func(w uint, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+
+	for i := 0; i < len(order); i += 3 {
+		emit(uint(digest[order[i]])<<16|uint(digest[order[i+1]])<<8|uint(digest[order[i+2]]), 4)
+	}
+
+	if len(digest) == 32 {
+		emit(uint(digest[31])<<8|uint(digest[30]), 3)
+	} else {
+		emit(uint(digest[63]), 2)
+	}
+
+	return b.String()
+}
+
+// Brings the rounds within the limits.  Zero is kept, as it stands for the absent "rounds=".
+func clampRounds(rounds int) int {
+	switch {
+	case rounds == 0:
+		return 0
+	case rounds < MinCryptRounds:
+		return MinCryptRounds
+	case rounds > MaxCryptRounds:
+		return MaxCryptRounds
+	}
+	return rounds
+}
+
+// Returns the rounds which are actually run.
+func effectiveRounds(rounds int) int {
+	if rounds == 0 {
+		return DefaultCryptRounds
+	}
+	return clampRounds(rounds)
+}
+
+// Returns the prefix of the algorithm, which is either SHA-256 or SHA-512.
+func cryptPrefix(algorithm string) (string, error) {
+	switch strings.Replace(strings.ToLower(algorithm), "-", "", -1) {
+	case "sha256":
+		return CryptSHA256Prefix, nil
+	case "sha512":
+		return CryptSHA512Prefix, nil
+	}
+	return "", ErrUnknownAlgorithm
+}
+
+// Returns a new random salt of the maximum length crypt(3) allows.
+func GenerateCryptSalt() (string, error) {
+	buf := make([]byte, cryptMaxSalt)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	for i, c := range buf {
+		buf[i] = cryptAlphabet[c&0x3f]
+	}
+
+	return string(buf), nil
+}
+
+// A PasswordEncoder for CryptHashes.  It only matches stored credentials in the crypt(3)
+// SHA-256 and SHA-512 formats, but their own parameters are used; see ModularCrypt for
+// matching several formats.
+type Crypt struct {
+	Algorithm string
+	Rounds    int
+}
+
+// Returns a new Crypt matcher, which encodes with the given algorithm and rounds.
+func NewCrypt(algorithm string, rounds int) *Crypt {
+	return &Crypt{Algorithm: algorithm, Rounds: rounds}
+}
+
+func (cm *Crypt) Match(token authc.AuthenticationToken, info authc.AuthenticationInfo) bool {
+	return matchHash(token, info, CryptSHA256Prefix, CryptSHA512Prefix)
+}
+
+func (cm *Crypt) Encode(password []byte) (string, error) {
+	salt, err := GenerateCryptSalt()
+
+	if err != nil {
+		return "", err
+	}
+
+	h, err := NewCryptHash(password, cm.Algorithm, cm.Rounds, salt)
+
+	if err != nil {
+		return "", err
+	}
+
+	return h.String(), nil
+}
+
+// Returns true, unless the credentials are a CryptHash with the same algorithm and rounds.
+func (cm *Crypt) NeedsRehash(credentials interface{}) bool {
+	stored, _ := credentialBytes(credentials)
+	h, err := ParseCryptHash(string(stored))
+
+	return err != nil || !sameAlgorithm(h.Algorithm, cm.Algorithm) || effectiveRounds(h.Rounds) != effectiveRounds(cm.Rounds)
+}
@@ -0,0 +1,71 @@
+package credential
+
+import (
+	"github.com/jalkanen/kuro/authc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// Made with glibc crypt(3)
+var cryptVectors = []struct {
+	password, hash string
+}{
+	{"Hello world!", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+	{"Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+	{"Hello world!", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+	{"Hello world!", "$6$rounds=1400$anotherlongsalts$5FGyu8c4BZDX4wJgs0Un26YOw2XibT5eTkHF1I1aP3QqStoJI9BHD2YPJYsAjEePVGUyBjdZxcNqMWlrrbIOC."},
+	{"Hello world!", "$5$rounds=5000$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+	{"", "$6$x$QSmr1Bx2g4O6BzKvdkgOcyU6H91X6I/XBv5pSalMhSPkwdH6Beo3F455xZJg0v//bxVK5F4OE5k1.0xuR26MK0"},
+}
+
+func TestCryptHash(t *testing.T) {
+	for _, v := range cryptVectors {
+		h, err := ParseCryptHash(v.hash)
+		require.NoError(t, err, v.hash)
+
+		assert.True(t, h.Verify([]byte(v.password)), v.hash)
+		assert.False(t, h.Verify([]byte(v.password+"!")), v.hash)
+		assert.Equal(t, v.hash, h.String())
+
+		made, err := NewCryptHash([]byte(v.password), h.Algorithm, h.Rounds, h.Salt)
+		require.NoError(t, err)
+		assert.Equal(t, v.hash, made.String())
+	}
+
+	h, err := NewCryptHash([]byte("pw"), "SHA-256", 10, "saltstringsaltstringsalt")
+	require.NoError(t, err)
+	assert.Equal(t, MinCryptRounds, h.Rounds)
+	assert.Equal(t, "saltstringsaltst", h.Salt)
+
+	for _, s := range []string{
+		"$5$",
+		"$5$salt",
+		"$5$salt$",
+		"$5$rounds=x$salt$hash",
+		"$5$saltstringsaltstring$hash",
+		"$1$salt$hash",
+	} {
+		_, err := ParseCryptHash(s)
+		assert.Error(t, err, s)
+	}
+
+	_, err = NewCryptHash([]byte("pw"), "MD5", 0, "salt")
+	assert.Equal(t, ErrUnknownAlgorithm, err)
+}
+
+func TestCryptMatcher(t *testing.T) {
+	m := NewCrypt("SHA-512", 1000)
+
+	stored, err := m.Encode([]byte("foopw"))
+	require.NoError(t, err)
+	assert.Regexp(t, `^\$6\$rounds=1000\$[./0-9A-Za-z]{16}\$[./0-9A-Za-z]{86}$`, stored)
+
+	assert.True(t, m.Match(authc.NewToken("foo", "foopw"), authc.NewAccount("foo", stored, "test")))
+	assert.False(t, m.Match(authc.NewToken("foo", "barpw"), authc.NewAccount("foo", stored, "test")))
+	assert.True(t, m.Match(authc.NewToken("foo", "Hello world!"), authc.NewAccount("foo", cryptVectors[0].hash, "test")))
+
+	shiro, err := NewShiro1("SHA-256", 1).Encode([]byte("foopw"))
+	require.NoError(t, err)
+	assert.False(t, m.Match(authc.NewToken("foo", "foopw"), authc.NewAccount("foo", shiro, "test")))
+}
@@ -0,0 +1,342 @@
+package kuro
+
+import (
+	"github.com/jalkanen/kuro/authz"
+	"github.com/jalkanen/kuro/realm"
+	"time"
+)
+
+// A Vote is the answer of a single realm to an authorization question.
+type Vote int
+
+const (
+	// The realm does not know the principals, and so has no opinion.
+	Abstain Vote = iota
+
+	// The realm grants the role or permission.
+	Grant
+
+	// The realm knows the principals, but does not grant the role or permission.
+	Deny
+)
+
+/*
+	An AuthorizationPolicy combines the Votes of all the realms into a single decision.  The votes
+	are given in the same order as the realms were added to the SecurityManager.
+*/
+type AuthorizationPolicy interface {
+	Decide(votes []Vote) bool
+}
+
+/***********************************************************************************************************
+
+	AnyGrantPolicy allows access if at least one realm grants it.  This is the default.
+
+ ***********************************************************************************************************/
+
+type AnyGrantPolicy struct{}
+
+func (p *AnyGrantPolicy) Decide(votes []Vote) bool {
+	for _, v := range votes {
+		if v == Grant {
+			return true
+		}
+	}
+	return false
+}
+
+/***********************************************************************************************************
+
+	AllGrantPolicy allows access only if every single realm grants it.  A realm which does not
+	know the principals counts against access.
+
+ ***********************************************************************************************************/
+
+type AllGrantPolicy struct{}
+
+func (p *AllGrantPolicy) Decide(votes []Vote) bool {
+	for _, v := range votes {
+		if v != Grant {
+			return false
+		}
+	}
+	return len(votes) > 0
+}
+
+/***********************************************************************************************************
+
+	DenyOverridesPolicy allows access if at least one realm grants it and no realm which knows the
+	principals denies it.  Realms which do not know the principals are ignored.
+
+ ***********************************************************************************************************/
+
+type DenyOverridesPolicy struct{}
+
+func (p *DenyOverridesPolicy) Decide(votes []Vote) bool {
+	granted := false
+
+	for _, v := range votes {
+		switch v {
+		case Deny:
+			return false
+		case Grant:
+			granted = true
+		}
+	}
+	return granted
+}
+
+/*
+	ModularRealmAuthorizer is an authz.Authorizer which asks every realm and combines the answers
+	with an AuthorizationPolicy.
+
+	A realm is considered not to know the principals (and so it abstains) if it is an AuthorizingRealm
+	which does not return AuthorizationInfo for them.  Otherwise, if the realm is an authz.Authorizer,
+	it is asked directly; if not, the roles and permissions in its AuthorizationInfo are checked.
+	Realms which are neither abstain.  An authz.Authorizer which is also a realm.AccountRealm is
+	asked whether it knows the principals with HasAccount(), so its AuthorizationInfo is not
+	resolved at all.
+*/
+type ModularRealmAuthorizer struct {
+	Policy AuthorizationPolicy
+
+	// If set, the roles in the AuthorizationInfo of realms which are not authz.Authorizers
+	// are resolved into permissions with this.
+	RolePermissionResolver authz.RolePermissionResolver
+
+	// Turns the permission strings into Permissions for realms which are not authz.Authorizers.
+	// If nil, authz.DefaultPermissionResolver is used.
+	PermissionResolver authz.PermissionResolver
+
+	// Time-bounded roles and permissions of realms which are not authz.Authorizers are checked
+	// against this.  If nil, authz.DefaultClock is used.
+	Clock authz.Clock
+
+	realms []realm.Realm
+}
+
+// Creates a new ModularRealmAuthorizer.  If policy is nil, AnyGrantPolicy is used.
+func NewModularRealmAuthorizer(realms []realm.Realm, policy AuthorizationPolicy) *ModularRealmAuthorizer {
+	if policy == nil {
+		policy = &AnyGrantPolicy{}
+	}
+
+	return &ModularRealmAuthorizer{
+		Policy: policy,
+		realms: realms,
+	}
+}
+
+func (a *ModularRealmAuthorizer) now() time.Time {
+	if a.Clock == nil {
+		return authz.DefaultClock.Now()
+	}
+
+	return a.Clock.Now()
+}
+
+// Asks every realm about n things at once, and returns the decision for each.  The Authorizer
+// function is used for realms which are authz.Authorizers, and fromInfo for the rest.
+func (a *ModularRealmAuthorizer) decide(principals []interface{}, n int,
+	authorizer func(authz.Authorizer) []bool,
+	fromInfo func(authz.AuthorizationInfo) []bool) []bool {
+
+	res := make([]bool, n)
+
+	if len(principals) == 0 || n == 0 {
+		return res
+	}
+
+	votes := make([][]Vote, n)
+
+	for _, re := range a.realms {
+		answers, known := a.ask(re, principals, authorizer, fromInfo)
+
+		for i := range votes {
+			v := Abstain
+
+			if known {
+				v = Deny
+				if i < len(answers) && answers[i] {
+					v = Grant
+				}
+			}
+
+			votes[i] = append(votes[i], v)
+		}
+	}
+
+	for i := range res {
+		res[i] = a.Policy.Decide(votes[i])
+	}
+
+	return res
+}
+
+// Returns the answers of a single realm, and whether the realm knows the principals at all.
+func (a *ModularRealmAuthorizer) ask(re realm.Realm, principals []interface{},
+	authorizer func(authz.Authorizer) []bool,
+	fromInfo func(authz.AuthorizationInfo) []bool) ([]bool, bool) {
+
+	if r, ok := re.(authz.Authorizer); ok {
+		if ar, ok := re.(realm.AccountRealm); ok {
+			if !ar.HasAccount(principals) {
+				return nil, false
+			}
+
+			return authorizer(r), true
+		}
+	}
+
+	var info authz.AuthorizationInfo
+
+	if r, ok := re.(realm.AuthorizingRealm); ok {
+		info, _ = r.AuthorizationInfo(principals)
+
+		if info == nil {
+			return nil, false
+		}
+	}
+
+	if r, ok := re.(authz.Authorizer); ok {
+		return authorizer(r), true
+	}
+
+	if info != nil {
+		return fromInfo(info), true
+	}
+
+	return nil, false
+}
+
+func (a *ModularRealmAuthorizer) HasRole(principals []interface{}, role string) bool {
+	return a.HasRoles(principals, role)[0]
+}
+
+func (a *ModularRealmAuthorizer) HasRoles(principals []interface{}, roles ...string) []bool {
+	return a.decide(principals, len(roles),
+		func(r authz.Authorizer) []bool {
+			return r.HasRoles(principals, roles...)
+		},
+		func(info authz.AuthorizationInfo) []bool {
+			infoRoles := authz.RolesAt(info, a.now())
+			res := make([]bool, len(roles))
+
+			for i, role := range roles {
+				res[i] = containsString(infoRoles, role)
+			}
+			return res
+		})
+}
+
+func (a *ModularRealmAuthorizer) HasAllRoles(principals []interface{}, roles ...string) bool {
+	return allTrue(a.HasRoles(principals, roles...))
+}
+
+func (a *ModularRealmAuthorizer) IsPermittedP(principals []interface{}, permission authz.Permission) bool {
+	return a.decide(principals, 1,
+		func(r authz.Authorizer) []bool {
+			return []bool{r.IsPermittedP(principals, permission)}
+		},
+		func(info authz.AuthorizationInfo) []bool {
+			return []bool{a.granted(info)(permission)}
+		})[0]
+}
+
+func (a *ModularRealmAuthorizer) IsPermitted(principals []interface{}, permission string) bool {
+	return a.IsPermittedEach(principals, permission)[0]
+}
+
+func (a *ModularRealmAuthorizer) IsPermittedEach(principals []interface{}, permissions ...string) []bool {
+	var compiled []authz.Permission
+
+	return a.decide(principals, len(permissions),
+		func(r authz.Authorizer) []bool {
+			return r.IsPermittedEach(principals, permissions...)
+		},
+		func(info authz.AuthorizationInfo) []bool {
+			if compiled == nil {
+				compiled = make([]authz.Permission, len(permissions))
+
+				for i, p := range permissions {
+					if cp, err := authz.ResolvePermission(a.PermissionResolver, p); err == nil {
+						compiled[i] = cp
+					}
+				}
+			}
+
+			implies := a.granted(info)
+			res := make([]bool, len(permissions))
+
+			for i, p := range compiled {
+				res[i] = p != nil && implies(p)
+			}
+			return res
+		})
+}
+
+func (a *ModularRealmAuthorizer) IsPermittedAll(principals []interface{}, permissions ...string) bool {
+	return allTrue(a.IsPermittedEach(principals, permissions...))
+}
+
+func (a *ModularRealmAuthorizer) IsPermittedAny(principals []interface{}, permissions ...string) bool {
+	for _, ok := range a.IsPermittedEach(principals, permissions...) {
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	Returns what the permission checks against the info are decided on.  The compiled
+	PermissionSet of the info is used as is, if it has one, and the permissions of the roles
+	are compiled once for all the checks.
+*/
+func (a *ModularRealmAuthorizer) grants(info authz.AuthorizationInfo) *authz.Grants {
+	now := a.now()
+	g := &authz.Grants{
+		Info:  info,
+		Roles: authz.RolesAt(info, now),
+		Sets:  []*authz.PermissionSet{authz.PermissionSetOf(info)},
+		Time:  now,
+	}
+
+	if a.RolePermissionResolver != nil {
+		var perms []authz.Permission
+
+		for _, role := range g.Roles {
+			perms = append(perms, a.RolePermissionResolver.ResolvePermissionsInRole(role)...)
+		}
+
+		g.Sets = append(g.Sets, authz.NewPermissionSet(perms...))
+	}
+
+	return g
+}
+
+// Returns a function which tells whether the permissions in the info, and those of its roles,
+// imply a given permission.
+func (a *ModularRealmAuthorizer) granted(info authz.AuthorizationInfo) func(authz.Permission) bool {
+	return a.grants(info).Permits
+}
+
+// Returns true, if the slice contains the given value.
+func containsString(slice []string, val string) bool {
+	for _, k := range slice {
+		if k == val {
+			return true
+		}
+	}
+	return false
+}
+
+func allTrue(vals []bool) bool {
+	for _, v := range vals {
+		if !v {
+			return false
+		}
+	}
+	return true
+}
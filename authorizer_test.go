@@ -0,0 +1,136 @@
+package kuro
+
+import (
+	"github.com/jalkanen/kuro/authc"
+	"github.com/jalkanen/kuro/authc/credential"
+	"github.com/jalkanen/kuro/authz"
+	"github.com/jalkanen/kuro/realm"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+var services string = `
+  [users]
+  backup = secret, reader
+  shared = secret, reader
+
+  [roles]
+  reader = files:read
+`
+
+var people string = `
+  [users]
+  alice = password, writer
+  shared = password, writer
+
+  [roles]
+  writer = files:read, files:write
+`
+
+func newPolicyManager(policy AuthorizationPolicy) *DefaultSecurityManager {
+	msm := newSecurityManager()
+	r1, _ := realm.NewIni("services", strings.NewReader(services))
+	r2, _ := realm.NewIni("people", strings.NewReader(people))
+	msm.AddRealm(r1)
+	msm.AddRealm(r2)
+	msm.SetAuthorizationPolicy(policy)
+
+	return msm
+}
+
+func TestAnyGrantPolicy(t *testing.T) {
+	msm := newPolicyManager(nil)
+
+	// The first realm does not know alice, but that must not stop the second one from answering
+	assert.True(t, msm.IsPermitted([]interface{}{"alice"}, "files:write"))
+	assert.True(t, msm.HasRole([]interface{}{"alice"}, "writer"))
+	assert.True(t, msm.IsPermitted([]interface{}{"shared"}, "files:write"))
+	assert.False(t, msm.IsPermitted([]interface{}{"backup"}, "files:write"))
+	assert.False(t, msm.IsPermitted([]interface{}{"nobody"}, "files:read"))
+	assert.Equal(t, []bool{true, true}, msm.HasRoles([]interface{}{"shared"}, "reader", "writer"))
+}
+
+func TestAllGrantPolicy(t *testing.T) {
+	msm := newPolicyManager(&AllGrantPolicy{})
+
+	assert.True(t, msm.IsPermitted([]interface{}{"shared"}, "files:read"))
+	assert.False(t, msm.IsPermitted([]interface{}{"shared"}, "files:write"))
+	assert.False(t, msm.IsPermitted([]interface{}{"alice"}, "files:read"), "Unknown in one realm")
+}
+
+func TestDenyOverridesPolicy(t *testing.T) {
+	msm := newPolicyManager(&DenyOverridesPolicy{})
+
+	assert.True(t, msm.IsPermitted([]interface{}{"alice"}, "files:write"))
+	assert.True(t, msm.IsPermitted([]interface{}{"shared"}, "files:read"))
+	assert.False(t, msm.IsPermitted([]interface{}{"shared"}, "files:write"), "Denied by the services realm")
+	assert.Equal(t, []bool{false, false}, msm.HasRoles([]interface{}{"shared"}, "reader", "writer"))
+}
+
+func TestPolicies(t *testing.T) {
+	votes := [][]Vote{
+		{},
+		{Abstain},
+		{Grant, Abstain},
+		{Grant, Deny},
+		{Grant, Grant},
+	}
+
+	anyGrant := []bool{false, false, true, true, true}
+	allGrant := []bool{false, false, false, false, true}
+	denyOverrides := []bool{false, false, true, false, true}
+
+	for i, v := range votes {
+		assert.Equal(t, anyGrant[i], (&AnyGrantPolicy{}).Decide(v), "AnyGrant %v", v)
+		assert.Equal(t, allGrant[i], (&AllGrantPolicy{}).Decide(v), "AllGrant %v", v)
+		assert.Equal(t, denyOverrides[i], (&DenyOverridesPolicy{}).Decide(v), "DenyOverrides %v", v)
+	}
+}
+
+// A SimpleAuthorizationInfo which counts how often its permissions are listed.
+type listingInfo struct {
+	*authz.SimpleAuthorizationInfo
+	listed int
+}
+
+func (i *listingInfo) Permissions() []authz.Permission {
+	i.listed++
+	return i.SimpleAuthorizationInfo.Permissions()
+}
+
+// An AuthorizingRealm which is not an Authorizer, and always returns the same info.
+type infoRealm struct {
+	info authz.AuthorizationInfo
+}
+
+func (r *infoRealm) Name() string                                       { return "info" }
+func (r *infoRealm) Supports(token authc.AuthenticationToken) bool      { return false }
+func (r *infoRealm) CredentialsMatcher() credential.CredentialsMatcher { return credential.NewPlain() }
+func (r *infoRealm) AuthenticationInfo(token authc.AuthenticationToken) (authc.AuthenticationInfo, error) {
+	return nil, realm.ErrUnknownAccount
+}
+func (r *infoRealm) AuthorizationInfo(principals []interface{}) (authz.AuthorizationInfo, error) {
+	return r.info, nil
+}
+
+func TestGrantedUsesPermissionSet(t *testing.T) {
+	info := &listingInfo{SimpleAuthorizationInfo: &authz.SimpleAuthorizationInfo{}}
+	info.AddRole("printer")
+	info.AddPermission("printers:*")
+
+	msm := newSecurityManager()
+	msm.SetRealm(&infoRealm{info: info})
+
+	principals := []interface{}{"foo"}
+
+	assert.Equal(t, []bool{true, true}, msm.IsPermittedEach(principals, "printers:print", "printers:manage"))
+
+	msm.SetRolePermissionResolver(authz.RolePermissionResolverFunc(func(role string) []authz.Permission {
+		p, _ := authz.ResolvePermission(nil, "!printers:manage")
+		return []authz.Permission{p}
+	}))
+
+	assert.Equal(t, []bool{true, false}, msm.IsPermittedEach(principals, "printers:print", "printers:manage"))
+	assert.Equal(t, 0, info.listed, "The compiled permissions must be used")
+}
@@ -1,15 +1,115 @@
 package authz
 
-import ()
+import (
+	"time"
+)
 
 type AuthorizationInfo interface {
 	Permissions() []Permission
 	Roles() []string
 }
 
+// A RolePermissionResolver turns a role name into the permissions the role grants.  This allows
+// realms which only know the role names of their users to take part in permission checks.
+type RolePermissionResolver interface {
+	ResolvePermissionsInRole(role string) []Permission
+}
+
+// RolePermissionResolverFunc allows an ordinary function to be used as a RolePermissionResolver.
+type RolePermissionResolverFunc func(role string) []Permission
+
+func (f RolePermissionResolverFunc) ResolvePermissionsInRole(role string) []Permission {
+	return f(role)
+}
+
+/*
+	Grants holds what the permission checks for a subject are decided on: the compiled
+	permissions of the subject and of its roles, and the time against which time-bounded grants
+	are checked.  Explanations of decisions are made from the same Grants, so they agree.
+*/
+type Grants struct {
+	Info AuthorizationInfo
+
+	// The roles whose permissions are in the Sets, inherited ones included
+	Roles []string
+
+	Sets []*PermissionSet
+	Time time.Time
+}
+
+// Returns true, if some of the Sets grant the permission, and none of them denies it.
+func (g *Grants) Permits(permission Permission) bool {
+	return PermittedAt(WithSubjectAttributes(permission, g.Info), g.Time, g.Sets...)
+}
+
+// Returns the first granted permission which implies the given one, or nil.
+func (g *Grants) GrantedBy(permission Permission) Permission {
+	permission = WithSubjectAttributes(permission, g.Info)
+
+	for _, s := range g.Sets {
+		if p := s.GrantedByAt(permission, g.Time); p != nil {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Returns the first DenyPermission which denies the given one, or nil.
+func (g *Grants) DeniedBy(permission Permission) Permission {
+	permission = WithSubjectAttributes(permission, g.Info)
+
+	for _, s := range g.Sets {
+		if p := s.DeniedByAt(permission, g.Time); p != nil {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Returns the compiled permissions of the AuthorizationInfo, if it has a PermissionSet() method
+// such as SimpleAuthorizationInfo does, and otherwise compiles them.  The result may be nil.
+func PermissionSetOf(info AuthorizationInfo) *PermissionSet {
+	if ps, ok := info.(interface{ PermissionSet() *PermissionSet }); ok {
+		return ps.PermissionSet()
+	}
+
+	return NewPermissionSet(info.Permissions()...)
+}
+
+// Returns the permissions of the AuthorizationInfo, plus the permissions its roles
+// resolve to.  The resolver may be nil, in which case only the permissions are returned.
+func ResolvePermissions(info AuthorizationInfo, resolver RolePermissionResolver) []Permission {
+	return ResolvePermissionsAt(info, resolver, DefaultClock.Now())
+}
+
+// Like ResolvePermissions(), but only the roles which are valid at the given time are resolved.
+func ResolvePermissionsAt(info AuthorizationInfo, resolver RolePermissionResolver, t time.Time) []Permission {
+	perms := info.Permissions()
+
+	if resolver == nil {
+		return perms
+	}
+
+	all := make([]Permission, len(perms), len(perms)+8)
+	copy(all, perms)
+
+	for _, role := range RolesAt(info, t) {
+		all = append(all, resolver.ResolvePermissionsInRole(role)...)
+	}
+
+	return all
+}
+
 type SimpleAuthorizationInfo struct {
-	roles       map[string]bool
-	permissions []Permission
+	// Used by AddPermission().  If nil, DefaultPermissionResolver is used.
+	PermissionResolver PermissionResolver
+
+	roles         map[string]bool
+	permissions   []Permission
+	permissionSet *PermissionSet
+	attributes    map[string]interface{}
 }
 
 func (a *SimpleAuthorizationInfo) Permissions() []Permission {
@@ -41,10 +141,48 @@ func (a *SimpleAuthorizationInfo) AddPermissionP(p Permission) {
 		a.permissions = make([]Permission, 0, 128) // TODO: Perhaps this is still better as a map rather than a slice of fixed cap?
 	}
 	a.permissions = append(a.permissions, p)
+
+	if a.permissionSet == nil {
+		a.permissionSet = NewPermissionSet()
+	}
+	a.permissionSet.Add(p)
+}
+
+// Returns the compiled permissions, denials included.  May be nil if no permissions have been added.
+func (a *SimpleAuthorizationInfo) PermissionSet() *PermissionSet {
+	return a.permissionSet
+}
+
+// Returns true, if any of the permissions implies the given one, and none of them denies it.
+func (a *SimpleAuthorizationInfo) IsPermittedP(permission Permission) bool {
+	return a.IsPermittedAt(permission, DefaultClock.Now())
+}
+
+// Like IsPermittedP(), but time-bounded permissions are checked against the given time.
+func (a *SimpleAuthorizationInfo) IsPermittedAt(permission Permission, t time.Time) bool {
+	if a.permissionSet == nil {
+		return false
+	}
+
+	return a.permissionSet.ImpliesAt(WithSubjectAttributes(permission, a), t)
+}
+
+// Sets an attribute of the subject, which conditional permissions can refer to as "subject.name".
+func (a *SimpleAuthorizationInfo) SetAttribute(name string, value interface{}) {
+	if a.attributes == nil {
+		a.attributes = make(map[string]interface{})
+	}
+
+	a.attributes[name] = value
+}
+
+// Implements SubjectAttributes
+func (a *SimpleAuthorizationInfo) Attributes() map[string]interface{} {
+	return a.attributes
 }
 
 func (a *SimpleAuthorizationInfo) AddPermission(p string) error {
-	wp, err := NewWildcardPermission(p)
+	wp, err := ResolvePermission(a.PermissionResolver, p)
 
 	if err != nil {
 		return err
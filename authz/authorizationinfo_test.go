@@ -43,3 +43,35 @@ func TestStringPermissions(t *testing.T) {
 
 	assert.Equal(t, 2, len(a.Permissions()), "Got wrong number of permissions for %v", a.Permissions())
 }
+
+func TestResolvePermissions(t *testing.T) {
+	var a = SimpleAuthorizationInfo{}
+	a.AddRole("printer")
+	a.AddPermission("foo:3")
+
+	assert.Equal(t, 1, len(ResolvePermissions(&a, nil)))
+
+	resolver := RolePermissionResolverFunc(func(role string) []Permission {
+		p, _ := NewWildcardPermission(role + ":print")
+		return []Permission{p}
+	})
+
+	perms := ResolvePermissions(&a, resolver)
+
+	assert.Equal(t, 2, len(perms))
+	assert.Equal(t, "printer:print", perms[1].String())
+	assert.Equal(t, 1, len(a.Permissions()), "The info must not be modified")
+}
+
+func TestDenyPermissions(t *testing.T) {
+	var a = SimpleAuthorizationInfo{}
+	a.AddPermission("!documents:*:delete")
+	a.AddPermission("documents:*")
+
+	read, _ := NewWildcardPermission("documents:12:read")
+	del, _ := NewWildcardPermission("documents:12:delete")
+
+	assert.True(t, a.IsPermittedP(read))
+	assert.False(t, a.IsPermittedP(del))
+	assert.Equal(t, "!documents:*:delete", a.Permissions()[0].String())
+}
@@ -1,42 +1,175 @@
 package authz
 
-import ()
+import (
+	"fmt"
+	"strings"
+)
 
+// An Authorizer answers authorization questions about a set of principals.  The bulk methods
+// should resolve whatever they need only once, not separately for each role or permission.
 type Authorizer interface {
 	HasRole(subjectPrincipal []interface{}, role string) bool
 	IsPermittedP(subjectPrincipal []interface{}, permission Permission) bool
 	IsPermitted(subjectPrincipal []interface{}, permission string) bool
+
+	// Returns one result per role, in the same order.
+	HasRoles(subjectPrincipal []interface{}, roles ...string) []bool
+	// Returns true if all the roles are present.  This is also true when no roles are given.
+	HasAllRoles(subjectPrincipal []interface{}, roles ...string) bool
+
+	// Returns one result per permission, in the same order.
+	IsPermittedEach(subjectPrincipal []interface{}, permissions ...string) []bool
+	// Returns true if all the permissions are granted.  This is also true when no permissions are given.
+	IsPermittedAll(subjectPrincipal []interface{}, permissions ...string) bool
+	// Returns true if at least one of the permissions is granted.
+	IsPermittedAny(subjectPrincipal []interface{}, permissions ...string) bool
+}
+
+// UnauthenticatedError is returned from authorization checks when the Subject has not
+// logged in at all, so it cannot be authorized for anything.
+type UnauthenticatedError struct {
+}
+
+func (e *UnauthenticatedError) Error() string {
+	return "The Subject is not logged in."
+}
+
+// UnauthorizedError is returned from authorization checks when the Subject is known, but lacks
+// a role or a permission.  Exactly one of Role or Permission is set.
+type UnauthorizedError struct {
+	Role       string
+	Permission string
+}
+
+func (e *UnauthorizedError) Error() string {
+	if e.Role != "" {
+		return fmt.Sprintf("The Subject does not have the role '%s'.", e.Role)
+	}
+
+	return fmt.Sprintf("The Subject does not have the permission '%s'.", e.Permission)
+}
+
+// RoleCycleError is returned when roles inherit from each other in a loop.  Roles lists
+// the roles on the loop, starting and ending with the same role.
+type RoleCycleError struct {
+	Roles []string
+}
+
+func (e *RoleCycleError) Error() string {
+	return fmt.Sprintf("Role inheritance loops: %s", strings.Join(e.Roles, " -> "))
 }
 
-// SimpleRole is a simple container for a name and a set of associated permissions.
+/*
+	SimpleRole is a simple container for a name and a set of associated permissions.  A role
+	may inherit from parent roles, which are given by name: whoever has the role also has the
+	parent roles, and so all their permissions.
+*/
 type SimpleRole struct {
-	name        string
-	permissions map[string]Permission
+	name          string
+	permissions   map[string]Permission
+	permissionSet *PermissionSet
+	parents       []string
 }
 
 func NewRole( name string ) *SimpleRole {
-	return &SimpleRole{name: name, permissions: make(map[string]Permission)}
+	return &SimpleRole{name: name, permissions: make(map[string]Permission), permissionSet: NewPermissionSet()}
 }
 
 func (sr *SimpleRole) AddPermission(p Permission) {
+	if _, exists := sr.permissions[p.String()]; exists {
+		return
+	}
+
 	sr.permissions[p.String()] = p
+	sr.permissionSet.Add(p)
 }
 
-func (sr *SimpleRole) Name() string {
-	return sr.name
+// Returns all the permissions of this role.
+func (sr *SimpleRole) Permissions() []Permission {
+	perms := make([]Permission, 0, len(sr.permissions))
+
+	for _, p := range sr.permissions {
+		perms = append(perms, p)
+	}
+
+	return perms
 }
 
-// Returns true, if this role implies the given permission
-func (sr *SimpleRole) IsPermitted(permission Permission) bool {
-	for _,p := range sr.permissions {
-		if p.Implies(permission) {
-			return true
+// Returns the compiled permissions of this role, denials included.
+func (sr *SimpleRole) PermissionSet() *PermissionSet {
+	return sr.permissionSet
+}
+
+// Makes this role inherit from the given role.
+func (sr *SimpleRole) AddParent(parent string) {
+	for _, p := range sr.parents {
+		if p == parent {
+			return
 		}
 	}
 
-	return false
+	sr.parents = append(sr.parents, parent)
+}
+
+// Returns the names of the roles this role directly inherits from.
+func (sr *SimpleRole) Parents() []string {
+	return sr.parents
+}
+
+func (sr *SimpleRole) Name() string {
+	return sr.name
+}
+
+// Returns true, if this role implies the given permission and does not deny it
+func (sr *SimpleRole) IsPermitted(permission Permission) bool {
+	return sr.permissionSet.Implies(permission)
 }
 
 func (sr *SimpleRole) String() string {
 	return sr.name
 }
+
+/*
+	Returns the given role and all the roles it inherits from, directly or through its parents,
+	each only once and the role itself first.  The parents function returns the direct parents
+	of a role.  If the inheritance loops, a *RoleCycleError is returned.
+*/
+func InheritedRoles(role string, parents func(role string) []string) ([]string, error) {
+	var res []string
+	seen := make(map[string]bool)
+	var path []string
+
+	var visit func(role string) error
+
+	visit = func(role string) error {
+		for i, p := range path {
+			if p == role {
+				return &RoleCycleError{Roles: append(append([]string{}, path[i:]...), role)}
+			}
+		}
+
+		if seen[role] {
+			return nil
+		}
+
+		seen[role] = true
+		res = append(res, role)
+		path = append(path, role)
+
+		for _, parent := range parents(role) {
+			if err := visit(parent); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+
+		return nil
+	}
+
+	if err := visit(role); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
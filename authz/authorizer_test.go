@@ -0,0 +1,36 @@
+package authz
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestInheritedRoles(t *testing.T) {
+	parents := map[string][]string{
+		"admin":   {"editor", "auditor"},
+		"editor":  {"viewer"},
+		"auditor": {"viewer"},
+	}
+
+	roles, err := InheritedRoles("admin", func(role string) []string { return parents[role] })
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "editor", "viewer", "auditor"}, roles)
+
+	parents["viewer"] = []string{"admin"}
+
+	_, err = InheritedRoles("editor", func(role string) []string { return parents[role] })
+
+	if assert.IsType(t, &RoleCycleError{}, err) {
+		assert.Equal(t, []string{"editor", "viewer", "admin", "editor"}, err.(*RoleCycleError).Roles)
+		assert.Equal(t, "Role inheritance loops: editor -> viewer -> admin -> editor", err.Error())
+	}
+}
+
+func TestSimpleRoleParents(t *testing.T) {
+	r := NewRole("admin")
+	r.AddParent("editor")
+	r.AddParent("editor")
+
+	assert.Equal(t, []string{"editor"}, r.Parents())
+}
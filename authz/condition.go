@@ -0,0 +1,320 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	// Separates the permission from its condition in the string form of a ConditionalPermission.
+	ConditionSeparator = " if "
+
+	resourceScope = "resource."
+	subjectScope  = "subject."
+)
+
+/*
+	A Condition decides whether a conditional grant applies, based on the attributes of the
+	subject and of the resource which is being accessed.
+*/
+type Condition interface {
+	Evaluate(subject, resource map[string]interface{}) bool
+	fmt.Stringer
+}
+
+/*
+	A ConditionalPermission grants its Permission only when its Condition holds.  Because the
+	condition needs attributes to look at, it only ever implies ResourcePermissions; a check
+	without a resource never passes.
+
+	The string form is the permission, followed by "if" and the condition, e.g.
+
+		documents:edit if resource.owner == subject.id
+		reports:read if region in subject.regions and resource.status != 'draft'
+
+	A condition is one or more comparisons joined with "and".  The comparisons are "==", "!="
+	and "in", whose right side must be a list, e.g. a []string.  The operands are either
+	"resource.name" or "subject.name" for an attribute, a bare name for a resource attribute,
+	or a quoted literal.  Values are compared by their fmt.Sprint() form, and a comparison with
+	a missing attribute is always false.
+*/
+type ConditionalPermission struct {
+	Permission Permission
+	Condition  Condition
+}
+
+func NewConditionalPermission(permission Permission, condition Condition) *ConditionalPermission {
+	return &ConditionalPermission{Permission: permission, Condition: condition}
+}
+
+func (c *ConditionalPermission) Implies(permission Permission) bool {
+	rp, ok := permission.(*ResourcePermission)
+
+	return ok && c.Permission.Implies(rp.Permission) && c.Condition.Evaluate(rp.Subject, rp.Resource)
+}
+
+func (c *ConditionalPermission) String() string {
+	return c.Permission.String() + ConditionSeparator + c.Condition.String()
+}
+
+/*
+	A ResourcePermission asks for a Permission on a particular resource.  It is what you check
+	against conditional grants; unconditional grants simply look at the wrapped Permission.
+
+	Subject holds the attributes of the subject.  Authorizers fill in the attributes of the
+	account they know about (see SubjectAttributes), so usually only "id" needs to be given.
+	The attributes of the account win over those given here.
+*/
+type ResourcePermission struct {
+	Permission Permission
+	Resource   map[string]interface{}
+	Subject    map[string]interface{}
+}
+
+func NewResourcePermission(permission Permission, resource, subject map[string]interface{}) *ResourcePermission {
+	return &ResourcePermission{Permission: permission, Resource: resource, Subject: subject}
+}
+
+// A ResourcePermission is a question, not a grant, so it never implies anything.
+func (r *ResourcePermission) Implies(permission Permission) bool {
+	return false
+}
+
+func (r *ResourcePermission) String() string {
+	return r.Permission.String()
+}
+
+// An AuthorizationInfo which implements SubjectAttributes provides the attributes which
+// conditional permissions can refer to as "subject.name".
+type SubjectAttributes interface {
+	Attributes() map[string]interface{}
+}
+
+/*
+	If the permission is a ResourcePermission and the info has SubjectAttributes, returns a copy
+	of the permission with the attributes added to its Subject.  The attributes of the info
+	replace those of the same name in the permission, so that whoever asks cannot pose as
+	somebody else.  Otherwise returns the permission as is.
+*/
+func WithSubjectAttributes(permission Permission, info interface{}) Permission {
+	rp, ok := permission.(*ResourcePermission)
+
+	if !ok {
+		return permission
+	}
+
+	sa, ok := info.(SubjectAttributes)
+
+	if !ok || len(sa.Attributes()) == 0 {
+		return permission
+	}
+
+	subject := make(map[string]interface{}, len(rp.Subject)+len(sa.Attributes()))
+
+	for k, v := range rp.Subject {
+		subject[k] = v
+	}
+
+	for k, v := range sa.Attributes() {
+		subject[k] = v
+	}
+
+	return NewResourcePermission(rp.Permission, rp.Resource, subject)
+}
+
+/*
+	Parses the string form of a ConditionalPermission.  The part before "if" is resolved
+	with the given resolver, or DefaultPermissionResolver if it is nil.
+*/
+func NewConditionalPermissionFromString(resolver PermissionResolver, s string) (*ConditionalPermission, error) {
+	// The space lets a trailing "if" without a condition be caught, too
+	s += " "
+	idx := strings.Index(s, ConditionSeparator)
+
+	if idx < 0 {
+		return nil, errors.New("Conditional permission has no condition: " + s)
+	}
+
+	p, err := ResolvePermission(resolver, strings.TrimSpace(s[:idx]))
+
+	if err != nil {
+		return nil, err
+	}
+
+	cond, err := ParseCondition(s[idx+len(ConditionSeparator):])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConditionalPermission(p, cond), nil
+}
+
+// Parses a condition, as described in ConditionalPermission.
+func ParseCondition(s string) (Condition, error) {
+	tokens, err := tokenize(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var cond attributeCondition
+
+	for len(tokens) > 0 {
+		if len(tokens) < 3 {
+			return nil, errors.New("Incomplete condition: " + s)
+		}
+
+		c := comparison{left: tokens[0], op: tokens[1], right: tokens[2]}
+
+		switch c.op.text {
+		case "==", "!=", "in":
+		default:
+			return nil, fmt.Errorf("Unknown operator '%s' in condition: %s", c.op.text, s)
+		}
+
+		cond = append(cond, c)
+		tokens = tokens[3:]
+
+		if len(tokens) > 0 {
+			if tokens[0].text != "and" || tokens[0].quoted || len(tokens) == 1 {
+				return nil, fmt.Errorf("Expected 'and' and another comparison after '%s' in condition: %s", c.right, s)
+			}
+			tokens = tokens[1:]
+		}
+	}
+
+	if len(cond) == 0 {
+		return nil, errors.New("Empty condition")
+	}
+
+	return cond, nil
+}
+
+// All the comparisons must hold.
+type attributeCondition []comparison
+
+func (c attributeCondition) Evaluate(subject, resource map[string]interface{}) bool {
+	for _, cmp := range c {
+		if !cmp.evaluate(subject, resource) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c attributeCondition) String() string {
+	parts := make([]string, len(c))
+
+	for i, cmp := range c {
+		parts[i] = cmp.left.String() + " " + cmp.op.text + " " + cmp.right.String()
+	}
+
+	return strings.Join(parts, " and ")
+}
+
+type comparison struct {
+	left, op, right token
+}
+
+func (c comparison) evaluate(subject, resource map[string]interface{}) bool {
+	left, ok := c.left.value(subject, resource)
+
+	if !ok {
+		return false
+	}
+
+	right, ok := c.right.value(subject, resource)
+
+	if !ok {
+		return false
+	}
+
+	switch c.op.text {
+	case "==":
+		return fmt.Sprint(left) == fmt.Sprint(right)
+	case "!=":
+		return fmt.Sprint(left) != fmt.Sprint(right)
+	case "in":
+		list := reflect.ValueOf(right)
+
+		if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+			return false
+		}
+
+		for i := 0; i < list.Len(); i++ {
+			if fmt.Sprint(list.Index(i).Interface()) == fmt.Sprint(left) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+// Returns the value of the operand, and whether it has one.
+func (t token) value(subject, resource map[string]interface{}) (interface{}, bool) {
+	if t.quoted {
+		return t.text, true
+	}
+
+	attrs, name := resource, t.text
+
+	switch {
+	case strings.HasPrefix(name, subjectScope):
+		attrs, name = subject, name[len(subjectScope):]
+	case strings.HasPrefix(name, resourceScope):
+		name = name[len(resourceScope):]
+	}
+
+	v, ok := attrs[name]
+
+	return v, ok && v != nil
+}
+
+func (t token) String() string {
+	if t.quoted {
+		return "'" + t.text + "'"
+	}
+	return t.text
+}
+
+// Splits the condition on whitespace, keeping quoted literals together.
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+
+	s = strings.TrimSpace(s)
+
+	for len(s) > 0 {
+		if s[0] == '\'' || s[0] == '"' {
+			end := strings.IndexByte(s[1:], s[0])
+
+			if end < 0 {
+				return nil, errors.New("Unterminated quote in condition")
+			}
+
+			tokens = append(tokens, token{text: s[1 : end+1], quoted: true})
+			s = strings.TrimSpace(s[end+2:])
+			continue
+		}
+
+		end := strings.IndexAny(s, " \t")
+
+		if end < 0 {
+			end = len(s)
+		}
+
+		tokens = append(tokens, token{text: s[:end]})
+		s = strings.TrimSpace(s[end:])
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,75 @@
+package authz
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func onResource(t *testing.T, perm string, resource, subject map[string]interface{}) *ResourcePermission {
+	p, err := NewWildcardPermission(perm)
+	require.NoError(t, err)
+
+	return NewResourcePermission(p, resource, subject)
+}
+
+func TestConditionalPermission(t *testing.T) {
+	p, err := ResolvePermission(nil, "documents:edit if resource.owner == subject.id")
+	require.NoError(t, err)
+	require.IsType(t, &ConditionalPermission{}, p)
+	assert.Equal(t, "documents:edit if resource.owner == subject.id", p.String())
+
+	alice := map[string]interface{}{"id": "alice"}
+
+	assert.True(t, p.Implies(onResource(t, "documents:edit", map[string]interface{}{"owner": "alice"}, alice)))
+	assert.False(t, p.Implies(onResource(t, "documents:edit", map[string]interface{}{"owner": "bob"}, alice)))
+	assert.False(t, p.Implies(onResource(t, "documents:delete", map[string]interface{}{"owner": "alice"}, alice)))
+	assert.False(t, p.Implies(onResource(t, "documents:edit", nil, alice)), "Missing attributes never match")
+
+	plain, _ := NewWildcardPermission("documents:edit")
+	assert.False(t, p.Implies(plain), "A condition cannot be checked without a resource")
+}
+
+func TestConditionIn(t *testing.T) {
+	p, err := ResolvePermission(nil, "reports:read if region in subject.regions and resource.status != 'draft'")
+	require.NoError(t, err)
+
+	subject := map[string]interface{}{"regions": []string{"eu", "us"}}
+
+	assert.True(t, p.Implies(onResource(t, "reports:read", map[string]interface{}{"region": "eu", "status": "final"}, subject)))
+	assert.False(t, p.Implies(onResource(t, "reports:read", map[string]interface{}{"region": "eu", "status": "draft"}, subject)))
+	assert.False(t, p.Implies(onResource(t, "reports:read", map[string]interface{}{"region": "apac", "status": "final"}, subject)))
+	assert.False(t, p.Implies(onResource(t, "reports:read", map[string]interface{}{"region": "eu", "status": "final"}, nil)))
+}
+
+func TestConditionErrors(t *testing.T) {
+	for _, s := range []string{
+		"documents:edit if",
+		"documents:edit if owner ==",
+		"documents:edit if owner ~ 'x'",
+		"documents:edit if owner == 'x' or owner == 'y'",
+		"documents:edit if owner == 'x' and",
+		"documents:edit if owner == 'x",
+	} {
+		_, err := ResolvePermission(nil, s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestConditionalPermissionSet(t *testing.T) {
+	a := SimpleAuthorizationInfo{}
+	require.NoError(t, a.AddPermission("documents:read"))
+	require.NoError(t, a.AddPermission("documents:edit if resource.owner == subject.id"))
+	require.NoError(t, a.AddPermission("!documents:edit if resource.locked == 'true'"))
+	a.SetAttribute("id", "alice")
+
+	own := map[string]interface{}{"owner": "alice"}
+
+	assert.True(t, a.IsPermittedP(onResource(t, "documents:read", own, nil)), "Unconditional grants apply to resources")
+	assert.True(t, a.IsPermittedP(onResource(t, "documents:edit", own, nil)))
+	assert.False(t, a.IsPermittedP(onResource(t, "documents:edit", map[string]interface{}{"owner": "bob"}, nil)))
+	assert.False(t, a.IsPermittedP(onResource(t, "documents:edit", map[string]interface{}{"owner": "alice", "locked": true}, nil)))
+	assert.True(t, a.IsPermittedP(onResource(t, "documents:edit", own, map[string]interface{}{"id": "alice"})))
+	assert.True(t, a.IsPermittedP(onResource(t, "documents:edit", own, map[string]interface{}{"id": "bob"})), "The attributes of the account win")
+	assert.False(t, a.IsPermittedP(onResource(t, "documents:edit", map[string]interface{}{"owner": "bob"}, map[string]interface{}{"id": "bob"})))
+}
@@ -0,0 +1,124 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+	A DomainPermission is a WildcardPermission with three well-known parts: the domain, the actions
+	and the targets, as in "printers:print,query:lp7200".  Use it instead of building permission
+	strings by hand, since the values are escaped so that e.g. an ID with a colon cannot break
+	the permission apart:
+
+		p, err := authz.NewDomainPermission("printers", []string{"print"}, []string{id})
+
+	If there are no targets, the permission covers all of them; if there are no actions either,
+	it covers the whole domain.  A value of "*" is a wildcard, not a literal asterisk.
+
+	The separators, "%" and whitespace are escaped as in URLs (see EscapePermissionPart), so
+	a WildcardPermission string which refers to such a value must be escaped in the same way.
+	Like WildcardPermissions, DomainPermissions are case-insensitive.
+
+	A DomainPermission and a WildcardPermission can imply each other either way.  It can only be
+	made with NewDomainPermission().
+*/
+type DomainPermission struct {
+	domain  string
+	actions []string
+	targets []string
+
+	wildcard *WildcardPermission
+}
+
+// Creates a new DomainPermission.  Returns an error if the domain or any of the values is empty.
+func NewDomainPermission(domain string, actions []string, targets []string) (*DomainPermission, error) {
+	if strings.TrimSpace(domain) == "" {
+		return nil, errors.New("Domain must not be empty")
+	}
+
+	if len(actions) == 0 && len(targets) > 0 {
+		actions = []string{WildcardToken}
+	}
+
+	parts := []map[string]bool{{escapeValue(domain): true}}
+
+	for _, values := range [][]string{actions, targets} {
+		if len(values) == 0 {
+			break
+		}
+
+		part := make(map[string]bool, len(values))
+
+		for _, v := range values {
+			if v == "" {
+				return nil, fmt.Errorf("Empty action or target in the domain '%s'", domain)
+			}
+			part[escapeValue(v)] = true
+		}
+
+		parts = append(parts, part)
+	}
+
+	return &DomainPermission{
+		domain:   domain,
+		actions:  append([]string(nil), actions...),
+		targets:  append([]string(nil), targets...),
+		wildcard: &WildcardPermission{parts: parts},
+	}, nil
+}
+
+// Returns the domain, unescaped.
+func (d *DomainPermission) Domain() string {
+	return d.domain
+}
+
+// Returns a copy of the actions, unescaped.  If there are targets but no actions were given,
+// this is "*".
+func (d *DomainPermission) Actions() []string {
+	return append([]string(nil), d.actions...)
+}
+
+// Returns a copy of the targets, unescaped.
+func (d *DomainPermission) Targets() []string {
+	return append([]string(nil), d.targets...)
+}
+
+// Returns the WildcardPermission this permission is equivalent to.
+func (d *DomainPermission) Wildcard() *WildcardPermission {
+	return d.wildcard
+}
+
+func (d *DomainPermission) Implies(permission Permission) bool {
+	return d.wildcard.Implies(permission)
+}
+
+func (d *DomainPermission) String() string {
+	return d.wildcard.String()
+}
+
+// Escapes a value so that it can be safely used as a part of a WildcardPermission string.
+func EscapePermissionPart(value string) string {
+	var b strings.Builder
+
+	for _, c := range []byte(value) {
+		switch c {
+		case ':', ',', '%', ' ', '\t', '\r', '\n':
+			fmt.Fprintf(&b, "%%%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// Escapes the value, and lowercases it as WildcardPermission does.  The wildcard is left as is.
+func escapeValue(value string) string {
+	if value == WildcardToken {
+		return value
+	}
+
+	return strings.ToLower(EscapePermissionPart(value))
+}
@@ -0,0 +1,71 @@
+package authz
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDomainPermission(t *testing.T) {
+	p, err := NewDomainPermission("printers", []string{"print", "query"}, []string{"lp7200"})
+	require.NoError(t, err)
+	assert.Equal(t, "printers:print,query:lp7200", p.String())
+
+	d, _ := NewDomainPermission("printers", nil, nil)
+	assert.Equal(t, "printers", d.String())
+
+	d, _ = NewDomainPermission("printers", nil, []string{"lp7200"})
+	assert.Equal(t, "printers:*:lp7200", d.String())
+
+	_, err = NewDomainPermission(" ", nil, nil)
+	assert.Error(t, err)
+
+	_, err = NewDomainPermission("printers", []string{""}, nil)
+	assert.Error(t, err)
+}
+
+func TestDomainPermissionEscaping(t *testing.T) {
+	p, err := NewDomainPermission("documents", []string{"read"}, []string{"urn:doc:12,13"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "documents:read:urn%3adoc%3a12%2c13", p.String())
+	assert.Equal(t, 3, len(p.Wildcard().parts))
+	assert.Equal(t, "documents", p.Domain())
+	assert.Equal(t, []string{"read"}, p.Actions())
+	assert.Equal(t, []string{"urn:doc:12,13"}, p.Targets())
+
+	// The values cannot be changed from the outside
+	actions := []string{"read"}
+	read, _ := NewDomainPermission("documents", actions, nil)
+	actions[0] = "write"
+	read.Actions()[0] = "delete"
+	assert.Equal(t, []string{"read"}, read.Actions())
+
+	other, _ := NewDomainPermission("documents", []string{"read"}, []string{"urn:doc:12"})
+	assert.False(t, p.Implies(other))
+	assert.False(t, other.Implies(p))
+
+	assert.Equal(t, "100%25%20sure", EscapePermissionPart("100% sure"))
+}
+
+func TestDomainPermissionInterop(t *testing.T) {
+	d, _ := NewDomainPermission("printers", []string{"print"}, []string{"lp7200"})
+	all, _ := NewDomainPermission("printers", []string{"*"}, nil)
+
+	w, _ := NewWildcardPermission("printers:*")
+	assert.True(t, w.Implies(d))
+	assert.True(t, d.Implies(wildcards(t, "printers:print:lp7200")[0]))
+	assert.False(t, d.Implies(wildcards(t, "printers:print")[0]))
+	assert.True(t, all.Implies(d))
+	assert.False(t, d.Implies(all))
+
+	escaped, _ := NewDomainPermission("documents", []string{"read"}, []string{"a:b"})
+	assert.True(t, wildcards(t, "documents:read:"+EscapePermissionPart("a:b"))[0].Implies(escaped))
+
+	set := NewPermissionSet(all, wildcards(t, "scanners:scan")[0])
+	assert.True(t, set.Implies(d))
+	assert.True(t, set.Implies(wildcards(t, "printers:query")[0]))
+
+	scan, _ := NewDomainPermission("scanners", []string{"scan"}, []string{"x"})
+	assert.True(t, set.Implies(scan))
+}
@@ -15,6 +15,7 @@ const (
 	WildcardSeparator    = ":"
 	WildcardSubSeparator = ","
 	WildcardToken        = "*"
+	DenyPrefix           = "!"
 )
 
 
@@ -46,6 +47,48 @@ func (p AllPermission) String() string {
 	return "*"
 }
 
+/*
+	A DenyPermission takes away whatever the wrapped Permission would grant.  In its string
+	form it is written with a leading exclamation mark, e.g. "!documents:*:delete".
+
+	Denials always win over grants: a permission is allowed only if at least one granted
+	Permission implies it, and no DenyPermission denies it.  The order in which the permissions
+	were added does not matter, and neither does whether they come from the account itself or
+	from one of its roles.  PermissionSet, SimpleAuthorizationInfo, SimpleRole, SimpleAccount and
+	SimpleAccountRealm all follow this rule.
+
+	Denials are evaluated within a single realm.  When there are several realms, a denial in
+	one of them is a Deny vote, and the AuthorizationPolicy of the SecurityManager decides
+	whether it overrides a grant from another realm.
+
+	A denial covers exactly the permissions its wrapped Permission would imply.  So
+	"!documents:*:delete" denies "documents:12:delete", but not a check for the broader
+	"documents"; code should check for the specific action it is about to perform.
+
+	DenyPermission itself never implies anything, so it cannot grant access by accident.
+*/
+type DenyPermission struct {
+	Permission Permission
+}
+
+func NewDenyPermission(permission Permission) *DenyPermission {
+	return &DenyPermission{Permission: permission}
+}
+
+// Always returns false; use Denies() instead.
+func (d *DenyPermission) Implies(permission Permission) bool {
+	return false
+}
+
+// Returns true, if this denial covers the given permission.
+func (d *DenyPermission) Denies(permission Permission) bool {
+	return d.Permission.Implies(permission)
+}
+
+func (d *DenyPermission) String() string {
+	return DenyPrefix + d.Permission.String()
+}
+
 /*
 	A WildcardPermission provides a simple structure for permissions.  Each
 	permission has multiple colon-separated parts, some of which can be wildcards (*).
@@ -86,8 +129,12 @@ func NewWildcardPermission(parts string) (*WildcardPermission, error) {
 }
 
 // Implements the Permission interface.  The incoming permission MUST be
-// another WildcardPermission.
+// another WildcardPermission, or a DomainPermission.
 func (w *WildcardPermission) Implies(permission Permission) bool {
+	if d, ok := permission.(*DomainPermission); ok {
+		permission = d.Wildcard()
+	}
+
 	otherPermission, ok := permission.(*WildcardPermission)
 	if !ok {
 		return false
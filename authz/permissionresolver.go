@@ -0,0 +1,205 @@
+package authz
+
+import (
+	"errors"
+	"github.com/jalkanen/kuro/cache"
+	"strings"
+)
+
+// A PermissionResolver turns the string representation of a permission into a Permission.
+type PermissionResolver interface {
+	ResolvePermission(permission string) (Permission, error)
+}
+
+// PermissionResolverFunc allows an ordinary function to be used as a PermissionResolver.
+type PermissionResolverFunc func(permission string) (Permission, error)
+
+func (f PermissionResolverFunc) ResolvePermission(permission string) (Permission, error) {
+	return f(permission)
+}
+
+// WildcardPermissionResolver resolves every string into a WildcardPermission.
+type WildcardPermissionResolver struct{}
+
+func (r *WildcardPermissionResolver) ResolvePermission(permission string) (Permission, error) {
+	p, err := NewWildcardPermission(permission)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+/*
+	CachingPermissionResolver remembers the Permissions another resolver has parsed, so that each
+	permission string is parsed only once.  Every caller gets the same instance, so only the
+	Permissions which cannot be changed are cached: WildcardPermissions, DomainPermissions and
+	AllPermissions.  The others, such as DenyPermissions and ConditionalPermissions with their
+	exported fields, are parsed anew every time.  The cache is bounded; when it is full, the
+	least recently used permissions are dropped.
+
+	Deny prefixes and conditions are parsed with the rest of the string, so the whole string is
+	the key.  Strings which fail to parse are not cached.
+*/
+type CachingPermissionResolver struct {
+	Resolver PermissionResolver
+	cache    *cache.LRUCache
+}
+
+// Creates a resolver which caches at most size permissions parsed by the given resolver.
+func NewCachingPermissionResolver(resolver PermissionResolver, size int) *CachingPermissionResolver {
+	return &CachingPermissionResolver{
+		Resolver: resolver,
+		cache:    cache.NewLRUCache(size),
+	}
+}
+
+func (r *CachingPermissionResolver) ResolvePermission(permission string) (Permission, error) {
+	if p, ok := r.cache.Get(permission).(Permission); ok {
+		return p, nil
+	}
+
+	p, err := ResolvePermission(r.Resolver, permission)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if immutable(p) {
+		r.cache.Set(permission, cache.Item{Value: p})
+	}
+
+	return p, nil
+}
+
+// Returns true, if the Permission cannot be changed once it has been made.
+func immutable(p Permission) bool {
+	switch p.(type) {
+	case *WildcardPermission, *DomainPermission, AllPermission, *AllPermission:
+		return true
+	}
+	return false
+}
+
+// Drops all the cached permissions.
+func (r *CachingPermissionResolver) Purge() {
+	r.cache.Purge()
+}
+
+const (
+	// The number of permissions DefaultPermissionResolver caches.
+	DefaultPermissionCacheSize = 4096
+)
+
+var (
+	// The PermissionResolver used wherever no other resolver has been configured.
+	DefaultPermissionResolver PermissionResolver = NewCachingPermissionResolver(&WildcardPermissionResolver{}, DefaultPermissionCacheSize)
+)
+
+/*
+	Resolves the permission with the given resolver, or with DefaultPermissionResolver if it is nil.
+
+	A string starting with DenyPrefix is resolved without the prefix and wrapped in a DenyPermission,
+	a string ending in a time window (see Validity) becomes a TimedPermission, and a string with
+	a condition (see ConditionalPermission) becomes a ConditionalPermission, in that order.
+*/
+func ResolvePermission(resolver PermissionResolver, permission string) (Permission, error) {
+	if resolver == nil {
+		resolver = DefaultPermissionResolver
+	}
+
+	if c, ok := resolver.(*CachingPermissionResolver); ok {
+		// Parses the prefixes and conditions itself
+		return c.ResolvePermission(permission)
+	}
+
+	if strings.HasPrefix(permission, DenyPrefix) {
+		rest := strings.TrimSpace(permission[len(DenyPrefix):])
+
+		if strings.HasPrefix(rest, DenyPrefix) {
+			return nil, errors.New("A permission can only be denied once: " + permission)
+		}
+
+		p, err := ResolvePermission(resolver, rest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewDenyPermission(p), nil
+	}
+
+	if rest, validity, err := ParseValidity(permission); err != nil {
+		return nil, err
+	} else if !validity.IsZero() {
+		p, err := ResolvePermission(resolver, rest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewTimedPermission(p, validity), nil
+	}
+
+	if strings.Contains(permission+" ", ConditionSeparator) {
+		p, err := NewConditionalPermissionFromString(resolver, permission)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return p, nil
+	}
+
+	return resolver.ResolvePermission(permission)
+}
+
+/*
+	PrefixPermissionResolver routes permission strings to different PermissionResolvers based on their
+	prefix.  For example, after
+
+		r.Register("acl:", aclResolver)
+
+	the string "acl:document/12:read" is resolved by aclResolver, which gets "document/12:read".  If
+	several prefixes match, the longest one wins.  Strings which match no prefix go to Default,
+	or to WildcardPermissionResolver if Default is nil.
+*/
+type PrefixPermissionResolver struct {
+	Default  PermissionResolver
+	prefixes map[string]PermissionResolver
+}
+
+func NewPrefixPermissionResolver() *PrefixPermissionResolver {
+	return &PrefixPermissionResolver{
+		prefixes: make(map[string]PermissionResolver),
+	}
+}
+
+// Registers a resolver for the given prefix.  The prefix is removed from the string before
+// it is handed to the resolver.
+func (r *PrefixPermissionResolver) Register(prefix string, resolver PermissionResolver) {
+	r.prefixes[prefix] = resolver
+}
+
+func (r *PrefixPermissionResolver) ResolvePermission(permission string) (Permission, error) {
+	best := ""
+	var resolver PermissionResolver
+
+	for prefix, pr := range r.prefixes {
+		if len(prefix) > len(best) && strings.HasPrefix(permission, prefix) {
+			best = prefix
+			resolver = pr
+		}
+	}
+
+	if resolver != nil {
+		return resolver.ResolvePermission(permission[len(best):])
+	}
+
+	if r.Default != nil {
+		return r.Default.ResolvePermission(permission)
+	}
+
+	return (&WildcardPermissionResolver{}).ResolvePermission(permission)
+}
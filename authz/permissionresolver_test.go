@@ -0,0 +1,125 @@
+package authz
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// A custom permission type which only implies an identical permission.
+type exactPermission string
+
+func (p exactPermission) Implies(other Permission) bool {
+	o, ok := other.(exactPermission)
+	return ok && o == p
+}
+
+func (p exactPermission) String() string {
+	return "exact:" + string(p)
+}
+
+var exactResolver = PermissionResolverFunc(func(s string) (Permission, error) {
+	return exactPermission(s), nil
+})
+
+func TestWildcardPermissionResolver(t *testing.T) {
+	p, err := ResolvePermission(nil, "foo:bar")
+
+	require.NoError(t, err)
+	assert.IsType(t, &WildcardPermission{}, p)
+
+	p, err = ResolvePermission(nil, ":::")
+
+	assert.Error(t, err)
+	assert.Nil(t, p)
+}
+
+func TestDoubleDeny(t *testing.T) {
+	for _, s := range []string{"!!foo:bar", "! !foo:bar"} {
+		_, err := ResolvePermission(nil, s)
+		assert.Error(t, err, s)
+	}
+
+	p, err := ResolvePermission(nil, "!foo:bar")
+	require.NoError(t, err)
+	assert.IsType(t, &DenyPermission{}, p)
+}
+
+func TestPrefixPermissionResolver(t *testing.T) {
+	r := NewPrefixPermissionResolver()
+	r.Register("exact:", exactResolver)
+
+	p, err := r.ResolvePermission("exact:foo:*")
+	require.NoError(t, err)
+	assert.Equal(t, exactPermission("foo:*"), p)
+
+	p, err = r.ResolvePermission("foo:*")
+	require.NoError(t, err)
+	assert.IsType(t, &WildcardPermission{}, p)
+
+	_, err = r.ResolvePermission("")
+	assert.Error(t, err)
+
+	r.Default = exactResolver
+	p, _ = r.ResolvePermission("foo:*")
+	assert.Equal(t, exactPermission("foo:*"), p)
+}
+
+func TestPrefixPermissionResolverLongest(t *testing.T) {
+	r := NewPrefixPermissionResolver()
+	r.Register("a:", exactResolver)
+	r.Register("a:b:", PermissionResolverFunc(func(s string) (Permission, error) {
+		return exactPermission("long " + s), nil
+	}))
+
+	p, _ := r.ResolvePermission("a:b:c")
+	assert.Equal(t, exactPermission("long c"), p)
+
+	p, _ = r.ResolvePermission("a:c")
+	assert.Equal(t, exactPermission("c"), p)
+}
+
+func TestAuthorizationInfoResolver(t *testing.T) {
+	r := NewPrefixPermissionResolver()
+	r.Register("exact:", exactResolver)
+
+	a := SimpleAuthorizationInfo{PermissionResolver: r}
+
+	require.NoError(t, a.AddPermission("exact:foo"))
+	require.NoError(t, a.AddPermission("bar:*"))
+
+	assert.Equal(t, exactPermission("foo"), a.Permissions()[0])
+	assert.IsType(t, &WildcardPermission{}, a.Permissions()[1])
+}
+
+func TestCachingPermissionResolver(t *testing.T) {
+	parsed := 0
+	r := NewCachingPermissionResolver(PermissionResolverFunc(func(s string) (Permission, error) {
+		parsed++
+		return NewWildcardPermission(s)
+	}), 2)
+
+	p1, err := ResolvePermission(r, "printers:print")
+	require.NoError(t, err)
+	p2, _ := ResolvePermission(r, "printers:print")
+
+	assert.True(t, p1 == p2, "The same Permission should be returned")
+	assert.Equal(t, 1, parsed)
+
+	deny, err := r.ResolvePermission("!printers:manage")
+	require.NoError(t, err)
+	assert.IsType(t, &DenyPermission{}, deny)
+
+	again, _ := r.ResolvePermission("!printers:manage")
+	assert.False(t, deny == again, "Permissions with exported fields must not be shared")
+	assert.Equal(t, 3, parsed)
+
+	// The cache only holds two permissions, so the first one has been dropped
+	r.ResolvePermission("scanners:scan")
+	r.ResolvePermission("scanners:copy")
+	r.ResolvePermission("printers:print")
+	assert.Equal(t, 6, parsed)
+
+	_, err = r.ResolvePermission(":::")
+	assert.Error(t, err)
+}
@@ -0,0 +1,321 @@
+package authz
+
+import (
+	"time"
+)
+
+/*
+	A PermissionSet compiles a set of granted Permissions so that checking whether any of them implies
+	a given permission does not require walking through all of them.
+
+	WildcardPermissions are stored in a trie with one level per part, so a check only follows
+	the matching token and the wildcard at each level, and takes time proportional to the number
+	of parts in the checked permission rather than the number of granted permissions.  Other types
+	of Permissions are checked one by one.
+
+	DenyPermissions are kept in a set of their own, which is checked first: if any of them denies
+	the permission, Implies() returns false no matter what was granted.  Otherwise the result is
+	the same as asking each granted Permission in turn.
+
+	A PermissionSet may be read from multiple goroutines, but Add() must not be called concurrently
+	with anything else.
+*/
+type PermissionSet struct {
+	root     *permissionNode
+	wildcard []*WildcardPermission
+	others   []Permission
+	denied   *PermissionSet
+
+	// Some of the permissions are TimedPermissions
+	timed bool
+}
+
+type permissionNode struct {
+	children map[string]*permissionNode
+
+	// A granted permission ends at this node.
+	end bool
+
+	// A granted permission continues from this node with nothing but wildcard parts.
+	wildcardTail bool
+}
+
+func newPermissionNode() *permissionNode {
+	return &permissionNode{children: make(map[string]*permissionNode, 2)}
+}
+
+// Creates a new PermissionSet containing the given permissions.
+func NewPermissionSet(permissions ...Permission) *PermissionSet {
+	s := &PermissionSet{root: newPermissionNode()}
+
+	for _, p := range permissions {
+		s.Add(p)
+	}
+
+	return s
+}
+
+// Returns the number of permissions added to the set, denials included.
+func (s *PermissionSet) Len() int {
+	n := len(s.wildcard) + len(s.others)
+
+	if s.denied != nil {
+		n += s.denied.Len()
+	}
+
+	return n
+}
+
+// Adds a new granted permission, or a DenyPermission, to the set.
+func (s *PermissionSet) Add(permission Permission) {
+	if d, ok := permission.(*DenyPermission); ok {
+		if s.denied == nil {
+			s.denied = NewPermissionSet()
+		}
+		s.denied.Add(d.Permission)
+		return
+	}
+
+	if _, ok := permission.(*TimedPermission); ok {
+		s.timed = true
+	}
+
+	if d, ok := permission.(*DomainPermission); ok {
+		permission = d.Wildcard()
+	}
+
+	wp, ok := permission.(*WildcardPermission)
+
+	if !ok {
+		s.others = append(s.others, permission)
+		return
+	}
+
+	s.wildcard = append(s.wildcard, wp)
+
+	// tail[i] is true if all the parts from i onwards contain a wildcard
+	tail := make([]bool, len(wp.parts)+1)
+	tail[len(wp.parts)] = true
+
+	for i := len(wp.parts) - 1; i >= 0; i-- {
+		tail[i] = tail[i+1] && wp.parts[i][WildcardToken]
+	}
+
+	// A part with several tokens is stored as a branch for each token.
+	level := []*permissionNode{s.root}
+
+	for i, part := range wp.parts {
+		next := make([]*permissionNode, 0, len(level)*len(part))
+
+		for _, n := range level {
+			if tail[i] {
+				n.wildcardTail = true
+			}
+
+			for token := range part {
+				child := n.children[token]
+
+				if child == nil {
+					child = newPermissionNode()
+					n.children[token] = child
+				}
+
+				next = append(next, child)
+			}
+		}
+
+		level = next
+	}
+
+	for _, n := range level {
+		n.end = true
+		n.wildcardTail = true
+	}
+}
+
+// Returns true, if any of the permissions or denials is a TimedPermission, so that the
+// answers of the set may change with time.  A nil set has no permissions at all.
+func (s *PermissionSet) TimeBounded() bool {
+	if s == nil {
+		return false
+	}
+
+	return s.timed || s.denied.TimeBounded()
+}
+
+// Returns true, if the set grants the given permission and does not deny it.  Time-bounded
+// grants are checked against DefaultClock.
+func (s *PermissionSet) Implies(permission Permission) bool {
+	return s.ImpliesAt(permission, DefaultClock.Now())
+}
+
+// Like Implies(), but time-bounded grants are checked against the given time.
+func (s *PermissionSet) ImpliesAt(permission Permission, t time.Time) bool {
+	return !s.DeniesAt(permission, t) && s.GrantsAt(permission, t)
+}
+
+// Returns true, if any DenyPermission in the set denies the given permission.
+func (s *PermissionSet) Denies(permission Permission) bool {
+	return s.DeniesAt(permission, DefaultClock.Now())
+}
+
+func (s *PermissionSet) DeniesAt(permission Permission, t time.Time) bool {
+	return s.denied != nil && s.denied.GrantsAt(permission, t)
+}
+
+// Returns true, if any granted permission in the set implies the given one.  Denials are
+// not taken into account.  For a ResourcePermission, the conditional grants get the whole
+// ResourcePermission, and the rest just the Permission it wraps.
+func (s *PermissionSet) Grants(permission Permission) bool {
+	return s.GrantsAt(permission, DefaultClock.Now())
+}
+
+func (s *PermissionSet) GrantsAt(permission Permission, t time.Time) bool {
+	target := grantTarget(permission)
+
+	for _, p := range s.others {
+		if impliesAt(p, permission, t) || (target != permission && impliesAt(p, target, t)) {
+			return true
+		}
+	}
+
+	wp, ok := target.(*WildcardPermission)
+
+	if !ok {
+		return false
+	}
+
+	for _, part := range wp.parts {
+		if len(part) != 1 {
+			// A granted permission must contain all of the tokens in a single part, which the
+			// trie cannot tell, so check these the slow way.
+			for _, p := range s.wildcard {
+				if p.Implies(wp) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	return s.root.implies(wp.parts)
+}
+
+/*
+	Returns the granted permission which implies the given one, or nil if none does.  Denials
+	are not taken into account.  This is meant for explaining decisions: it checks every
+	permission in turn, so it is slower than GrantsAt().  A nil set grants nothing.
+*/
+func (s *PermissionSet) GrantedByAt(permission Permission, t time.Time) Permission {
+	if s == nil {
+		return nil
+	}
+
+	target := grantTarget(permission)
+
+	for _, p := range s.others {
+		if impliesAt(p, permission, t) || (target != permission && impliesAt(p, target, t)) {
+			return p
+		}
+	}
+
+	if wp, ok := target.(*WildcardPermission); ok {
+		for _, p := range s.wildcard {
+			if p.Implies(wp) {
+				return p
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the DenyPermission which denies the given permission, or nil if none does.
+func (s *PermissionSet) DeniedByAt(permission Permission, t time.Time) Permission {
+	if s == nil {
+		return nil
+	}
+
+	if p := s.denied.GrantedByAt(permission, t); p != nil {
+		return NewDenyPermission(p)
+	}
+
+	return nil
+}
+
+// Returns what the granted permissions are checked against: the Permission a ResourcePermission
+// wraps, and DomainPermissions as WildcardPermissions.
+func grantTarget(permission Permission) Permission {
+	target := permission
+
+	if rp, ok := permission.(*ResourcePermission); ok {
+		target = rp.Permission
+	}
+
+	if d, ok := target.(*DomainPermission); ok {
+		target = d.Wildcard()
+	}
+
+	return target
+}
+
+func impliesAt(granted, permission Permission, t time.Time) bool {
+	if tp, ok := granted.(*TimedPermission); ok {
+		return tp.ImpliesAt(permission, t)
+	}
+
+	return granted.Implies(permission)
+}
+
+/*
+	Decides a permission over several PermissionSets, e.g. those of an account and all of its
+	roles.  A denial in any of the sets wins over grants in all the others.  Nil sets are skipped.
+*/
+func PermittedBy(permission Permission, sets ...*PermissionSet) bool {
+	return PermittedAt(permission, DefaultClock.Now(), sets...)
+}
+
+// Like PermittedBy(), but time-bounded grants are checked against the given time.
+func PermittedAt(permission Permission, t time.Time, sets ...*PermissionSet) bool {
+	granted := false
+
+	for _, s := range sets {
+		if s == nil {
+			continue
+		}
+
+		if s.DeniesAt(permission, t) {
+			return false
+		}
+
+		granted = granted || s.GrantsAt(permission, t)
+	}
+
+	return granted
+}
+
+// Each part has exactly one token here.
+func (n *permissionNode) implies(parts []map[string]bool) bool {
+	if n.end {
+		// The granted permission is shorter, so everything after it is implied
+		return true
+	}
+
+	if len(parts) == 0 {
+		return n.wildcardTail
+	}
+
+	for token := range parts[0] {
+		if child := n.children[token]; child != nil && child.implies(parts[1:]) {
+			return true
+		}
+
+		if token != WildcardToken {
+			if child := n.children[WildcardToken]; child != nil && child.implies(parts[1:]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,157 @@
+package authz
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func wildcards(t *testing.T, perms ...string) []Permission {
+	res := make([]Permission, len(perms))
+
+	for i, s := range perms {
+		p, err := NewWildcardPermission(s)
+		require.NoError(t, err)
+		res[i] = p
+	}
+
+	return res
+}
+
+// The set must always agree with asking each permission in turn.
+func TestPermissionSetMatchesLinear(t *testing.T) {
+	granted := wildcards(t,
+		"printer:print",
+		"printer:query:lp7200",
+		"newsletter:*:12",
+		"files:read,write",
+		"admin",
+		"*:view",
+		"a:b:*:*",
+		"x,y:z",
+	)
+
+	requested := wildcards(t,
+		"printer:print",
+		"printer:print:lp7200",
+		"printer:query",
+		"printer:query:lp7200",
+		"printer:query:epson",
+		"newsletter:edit:12",
+		"newsletter:edit:13",
+		"newsletter:edit",
+		"files:read",
+		"files:write:foo",
+		"files:read,write",
+		"files:read,delete",
+		"files:*",
+		"admin:anything:at:all",
+		"reports:view",
+		"reports:edit",
+		"a:b",
+		"a:b:c",
+		"a:b:c:d:e",
+		"a:c",
+		"x:z",
+		"x,y:z",
+		"y:z:q",
+		"z:z",
+		"*",
+	)
+
+	for _, g := range granted {
+		for _, r := range requested {
+			set := NewPermissionSet(g)
+			assert.Equal(t, g.Implies(r), set.Implies(r), "%s implies %s", g, r)
+		}
+	}
+
+	set := NewPermissionSet(granted...)
+	assert.Equal(t, len(granted), set.Len())
+
+	for _, r := range requested {
+		linear := false
+		for _, g := range granted {
+			linear = linear || g.Implies(r)
+		}
+
+		assert.Equal(t, linear, set.Implies(r), "set implies %s", r)
+	}
+}
+
+func TestPermissionSetOtherTypes(t *testing.T) {
+	set := NewPermissionSet(exactPermission("doc/12"))
+	set.Add(wildcards(t, "printer:print")[0])
+
+	assert.True(t, set.Implies(exactPermission("doc/12")))
+	assert.False(t, set.Implies(exactPermission("doc/13")))
+	assert.True(t, set.Implies(wildcards(t, "printer:print")[0]))
+	assert.False(t, set.Implies(wildcards(t, "doc/12")[0]))
+
+	assert.True(t, NewPermissionSet(&AllPermission{}).Implies(wildcards(t, "anything:at:all")[0]))
+	assert.False(t, NewPermissionSet().Implies(wildcards(t, "anything")[0]))
+}
+
+func TestPermissionSetLarge(t *testing.T) {
+	set := NewPermissionSet()
+
+	for i := 0; i < 5000; i++ {
+		set.Add(wildcards(t, fmt.Sprintf("document:%d:read,write", i))[0])
+	}
+
+	assert.True(t, set.Implies(wildcards(t, "document:4999:write")[0]))
+	assert.False(t, set.Implies(wildcards(t, "document:5000:write")[0]))
+	assert.False(t, set.Implies(wildcards(t, "document:12:delete")[0]))
+}
+
+func BenchmarkPermissionSet(b *testing.B) {
+	set := NewPermissionSet()
+
+	for i := 0; i < 10000; i++ {
+		p, _ := NewWildcardPermission(fmt.Sprintf("document:%d:read,write", i))
+		set.Add(p)
+	}
+
+	p, _ := NewWildcardPermission("document:9999:write")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Implies(p)
+	}
+}
+
+func TestPermissionSetDeny(t *testing.T) {
+	deny, err := ResolvePermission(nil, "!documents:*:delete")
+	require.NoError(t, err)
+	require.IsType(t, &DenyPermission{}, deny)
+	assert.Equal(t, "!documents:*:delete", deny.String())
+	assert.False(t, deny.Implies(wildcards(t, "documents:12:delete")[0]), "A denial must never grant")
+
+	// The order of grants and denials must not matter
+	for _, set := range []*PermissionSet{
+		NewPermissionSet(append(wildcards(t, "documents:*"), deny)...),
+		NewPermissionSet(deny, wildcards(t, "documents:*")[0]),
+	} {
+		assert.Equal(t, 2, set.Len())
+		assert.True(t, set.Implies(wildcards(t, "documents:12:read")[0]))
+		assert.False(t, set.Implies(wildcards(t, "documents:12:delete")[0]))
+		assert.True(t, set.Implies(wildcards(t, "documents")[0]), "The denial only covers what it implies")
+		assert.True(t, set.Denies(wildcards(t, "documents:12:delete")[0]))
+		assert.True(t, set.Grants(wildcards(t, "documents:12:delete")[0]))
+	}
+
+	// A denial alone grants nothing
+	assert.False(t, NewPermissionSet(deny).Implies(wildcards(t, "documents:12:read")[0]))
+
+	// A denial in one set overrides a grant in another
+	grants := NewPermissionSet(wildcards(t, "documents:*")...)
+	denials := NewPermissionSet(deny)
+
+	assert.True(t, PermittedBy(wildcards(t, "documents:12:read")[0], grants, denials, nil))
+	assert.False(t, PermittedBy(wildcards(t, "documents:12:delete")[0], grants, denials))
+	assert.False(t, PermittedBy(wildcards(t, "documents:12:read")[0]))
+
+	_, err = ResolvePermission(nil, "!")
+	assert.Error(t, err)
+}
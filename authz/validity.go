@@ -0,0 +1,240 @@
+package authz
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// Start a time window at the end of a role or permission string.
+	ValidFromKeyword  = "from"
+	ValidUntilKeyword = "until"
+
+	dateFormat = "2006-01-02"
+)
+
+// A Clock tells the current time.  Time-bounded grants are checked against it.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc allows an ordinary function, such as time.Now, to be used as a Clock.
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+var (
+	// The Clock used wherever no other Clock has been configured.  Replace it in tests, or
+	// give a Clock to the components which take one.
+	DefaultClock Clock = ClockFunc(time.Now)
+)
+
+/*
+	A Validity limits a grant to a time window, from From (inclusive) until Until (exclusive).
+	A zero From or Until leaves that end of the window open, so the zero Validity is valid
+	all the time.
+
+	In strings, the window is written at the end of a role or a permission:
+
+		contractor until 2025-12-31
+		documents:read from 2025-01-01T08:00:00Z until 2025-01-01T20:00:00Z
+
+	The times are either RFC 3339 timestamps or plain dates in UTC.  A date in "from" means
+	the start of that day, and a date in "until" the end of it.
+*/
+type Validity struct {
+	From  time.Time
+	Until time.Time
+}
+
+// Returns true, if the time is within the window.
+func (v Validity) Contains(t time.Time) bool {
+	return (v.From.IsZero() || !t.Before(v.From)) && (v.Until.IsZero() || t.Before(v.Until))
+}
+
+// Returns true, if the window is open at both ends.
+func (v Validity) IsZero() bool {
+	return v.From.IsZero() && v.Until.IsZero()
+}
+
+func (v Validity) String() string {
+	var parts []string
+
+	if !v.From.IsZero() {
+		parts = append(parts, ValidFromKeyword+" "+v.From.Format(time.RFC3339))
+	}
+
+	if !v.Until.IsZero() {
+		parts = append(parts, ValidUntilKeyword+" "+v.Until.Format(time.RFC3339))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+/*
+	Splits a trailing time window off the string, and returns the rest of the string along
+	with the Validity.  If there is no window, the string is returned as is, with a zero
+	Validity.  Keywords within quoted literals, as in conditions, do not start a window.  Returns an error if a "from" or "until" is followed by something which is not
+	a time.
+*/
+func ParseValidity(s string) (string, Validity, error) {
+	var v Validity
+
+	s = strings.TrimSpace(s)
+
+	for {
+		from := lastUnquoted(s, " "+ValidFromKeyword+" ")
+		until := lastUnquoted(s, " "+ValidUntilKeyword+" ")
+
+		if from < 0 && until < 0 {
+			break
+		}
+
+		keyword, idx := ValidUntilKeyword, until
+
+		if from > until {
+			keyword, idx = ValidFromKeyword, from
+		}
+
+		value := strings.TrimSpace(s[idx+len(keyword)+2:])
+
+		if strings.ContainsAny(value, " \t") {
+			// Not at the end, so it belongs to the rest of the string
+			break
+		}
+
+		t, err := parseTime(value, keyword == ValidUntilKeyword)
+
+		if err != nil {
+			return "", Validity{}, err
+		}
+
+		if keyword == ValidFromKeyword {
+			if !v.From.IsZero() {
+				return "", Validity{}, fmt.Errorf("More than one '%s' in '%s'", keyword, s)
+			}
+			v.From = t
+		} else {
+			if !v.Until.IsZero() {
+				return "", Validity{}, fmt.Errorf("More than one '%s' in '%s'", keyword, s)
+			}
+			v.Until = t
+		}
+
+		s = strings.TrimSpace(s[:idx])
+	}
+
+	return s, v, nil
+}
+
+// Returns the index of the last occurrence of sub which is not within single or double quotes,
+// or -1 if there is none.
+func lastUnquoted(s, sub string) int {
+	last := -1
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(s[i:], sub):
+			last = i
+		}
+	}
+
+	return last
+}
+
+func parseTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(dateFormat, value)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time '%s'; use a date like 2006-01-02 or an RFC 3339 timestamp", value)
+	}
+
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
+
+/*
+	A TimedPermission grants its Permission only within its Validity.  Implies() checks the
+	window against DefaultClock; PermissionSets and realms which have a Clock of their own call
+	ImpliesAt() with their time instead.
+*/
+type TimedPermission struct {
+	Permission Permission
+	Validity   Validity
+}
+
+func NewTimedPermission(permission Permission, validity Validity) *TimedPermission {
+	return &TimedPermission{Permission: permission, Validity: validity}
+}
+
+func (t *TimedPermission) Implies(permission Permission) bool {
+	return t.ImpliesAt(permission, DefaultClock.Now())
+}
+
+// Returns true, if the window contains the given time, and the wrapped Permission implies
+// the given one.
+func (t *TimedPermission) ImpliesAt(permission Permission, at time.Time) bool {
+	return t.Validity.Contains(at) && t.Permission.Implies(permission)
+}
+
+func (t *TimedPermission) String() string {
+	return t.Permission.String() + " " + t.Validity.String()
+}
+
+// Limits the permission to the window.  A denial stays a denial, which only applies within
+// the window.  If the Validity is zero, the permission is returned as is.
+func WithValidity(permission Permission, validity Validity) Permission {
+	if validity.IsZero() {
+		return permission
+	}
+
+	if d, ok := permission.(*DenyPermission); ok {
+		return NewDenyPermission(NewTimedPermission(d.Permission, validity))
+	}
+
+	return NewTimedPermission(permission, validity)
+}
+
+// Implemented by AuthorizationInfos whose roles may be limited in time.
+type TimedRoles interface {
+	RolesAt(t time.Time) []string
+}
+
+/*
+	Returns true, if some of the roles or permissions of the info are limited in time, so that
+	the decisions made with it may change at any moment.  An info may tell this itself with a
+	TimeBounded() method, as authc.SimpleAccount does; otherwise its permissions are checked.
+*/
+func TimeBounded(info AuthorizationInfo) bool {
+	if tb, ok := info.(interface{ TimeBounded() bool }); ok {
+		return tb.TimeBounded()
+	}
+
+	return PermissionSetOf(info).TimeBounded()
+}
+
+// Returns the roles of the info which are valid at the given time.
+func RolesAt(info AuthorizationInfo, t time.Time) []string {
+	if tr, ok := info.(TimedRoles); ok {
+		return tr.RolesAt(t)
+	}
+
+	return info.Roles()
+}
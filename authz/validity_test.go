@@ -0,0 +1,142 @@
+package authz
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func TestParseValidity(t *testing.T) {
+	rest, v, err := ParseValidity("contractor until 2025-12-31")
+	require.NoError(t, err)
+	assert.Equal(t, "contractor", rest)
+	assert.True(t, v.From.IsZero())
+	assert.Equal(t, date("2026-01-01T00:00:00Z"), v.Until, "A date means the end of that day")
+
+	rest, v, err = ParseValidity("documents:read from 2025-01-01T08:00:00Z until 2025-01-01T20:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, "documents:read", rest)
+	assert.Equal(t, date("2025-01-01T08:00:00Z"), v.From)
+	assert.Equal(t, date("2025-01-01T20:00:00Z"), v.Until)
+
+	rest, v, err = ParseValidity("  oncall from 2025-03-01 ")
+	require.NoError(t, err)
+	assert.Equal(t, "oncall", rest)
+	assert.Equal(t, date("2025-03-01T00:00:00Z"), v.From)
+
+	rest, v, err = ParseValidity("documents:edit if resource.owner == subject.id")
+	require.NoError(t, err)
+	assert.Equal(t, "documents:edit if resource.owner == subject.id", rest)
+	assert.True(t, v.IsZero())
+
+	rest, v, err = ParseValidity("mail:read if resource.note == 'sent from home' until 2025-12-31")
+	require.NoError(t, err)
+	assert.Equal(t, "mail:read if resource.note == 'sent from home'", rest)
+	assert.Equal(t, date("2026-01-01T00:00:00Z"), v.Until)
+
+	for _, s := range []string{
+		"mail:read if resource.note == 'sent from home'",
+		`mail:read if resource.note == "open until done"`,
+	} {
+		rest, v, err = ParseValidity(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, s, rest)
+		assert.True(t, v.IsZero(), s)
+
+		_, err = ResolvePermission(nil, s)
+		assert.NoError(t, err, s)
+	}
+
+	for _, s := range []string{
+		"contractor until tomorrow",
+		"contractor until 2025-13-01",
+		"contractor until 2025-01-01 until 2025-02-01",
+	} {
+		_, _, err = ParseValidity(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestValidityContains(t *testing.T) {
+	v := Validity{From: date("2025-01-01T00:00:00Z"), Until: date("2025-02-01T00:00:00Z")}
+
+	assert.False(t, v.Contains(date("2024-12-31T23:59:59Z")))
+	assert.True(t, v.Contains(date("2025-01-01T00:00:00Z")))
+	assert.True(t, v.Contains(date("2025-01-31T23:59:59Z")))
+	assert.False(t, v.Contains(date("2025-02-01T00:00:00Z")))
+
+	assert.True(t, Validity{}.Contains(time.Now()))
+	assert.Equal(t, "from 2025-01-01T00:00:00Z until 2025-02-01T00:00:00Z", v.String())
+}
+
+func TestTimedPermission(t *testing.T) {
+	before := date("2025-06-01T00:00:00Z")
+	during := date("2026-01-01T00:00:00Z")
+	after := date("2026-07-01T00:00:00Z")
+
+	p, err := ResolvePermission(nil, "documents:* from 2025-12-01 until 2026-05-31")
+	require.NoError(t, err)
+	require.IsType(t, &TimedPermission{}, p)
+
+	deny, err := ResolvePermission(nil, "!documents:*:delete until 2026-01-31")
+	require.NoError(t, err)
+	require.IsType(t, &DenyPermission{}, deny)
+
+	set := NewPermissionSet(p, deny)
+	del := wildcards(t, "documents:12:delete")[0]
+	read := wildcards(t, "documents:12:read")[0]
+
+	assert.False(t, set.ImpliesAt(read, before))
+	assert.True(t, set.ImpliesAt(read, during))
+	assert.False(t, set.ImpliesAt(read, after))
+
+	assert.True(t, set.DeniesAt(del, before))
+	assert.False(t, set.ImpliesAt(del, during), "The denial is still in effect")
+	assert.True(t, set.GrantsAt(del, during))
+	assert.False(t, set.DeniesAt(del, after))
+
+	assert.True(t, set.TimeBounded())
+	assert.True(t, NewPermissionSet(read, deny).TimeBounded())
+	assert.False(t, NewPermissionSet(read, del).TimeBounded())
+	assert.False(t, (*PermissionSet)(nil).TimeBounded())
+
+	assert.True(t, PermittedAt(read, during, set, nil))
+	assert.False(t, PermittedAt(read, after, set))
+
+	// Implies() follows DefaultClock
+	defer func(c Clock) { DefaultClock = c }(DefaultClock)
+	DefaultClock = ClockFunc(func() time.Time { return during })
+
+	assert.True(t, p.Implies(read))
+	assert.True(t, set.Implies(read))
+
+	DefaultClock = ClockFunc(func() time.Time { return after })
+
+	assert.False(t, p.Implies(read))
+	assert.False(t, set.Implies(read))
+
+	assert.Equal(t, "documents:* from 2025-12-01T00:00:00Z until 2026-06-01T00:00:00Z", p.String())
+}
+
+func TestWithValidity(t *testing.T) {
+	p := wildcards(t, "documents:read")[0]
+
+	assert.Equal(t, p, WithValidity(p, Validity{}))
+
+	v := Validity{Until: date("2026-01-01T00:00:00Z")}
+	deny := WithValidity(NewDenyPermission(p), v)
+
+	require.IsType(t, &DenyPermission{}, deny)
+	assert.IsType(t, &TimedPermission{}, deny.(*DenyPermission).Permission)
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"container/list"
 	"time"
 	"sync"
 )
@@ -127,3 +128,129 @@ func (c *MemoryCache) Purge() {
 func (item *Item) IsExpired() bool {
 	return item.expires.Before(time.Now())
 }
+
+/****************************************************************
+
+	LRUCache is a bounded memory cache.  When it is full, the least
+	recently used item is dropped to make room for a new one.  Items
+	expire after their Maxage like in MemoryCache, except that a
+	zero Maxage means the item never expires.
+
+*****************************************************************/
+type LRUCache struct {
+	mutex sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type lruEntry struct {
+	key  string
+	item Item
+}
+
+// Creates a new LRUCache which holds at most size items.
+func NewLRUCache(size int) *LRUCache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &LRUCache{
+		size:  size,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+func (c *LRUCache) Set(key string, item Item) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if item.Maxage > 0 {
+		item.expires = time.Now().Add(item.Maxage)
+	}
+
+	if e, gotit := c.items[key]; gotit {
+		e.Value.(*lruEntry).item = item
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, item: item})
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRUCache) Get(key string) interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, gotit := c.items[key]
+
+	if !gotit {
+		return nil
+	}
+
+	entry := e.Value.(*lruEntry)
+
+	if entry.item.Maxage > 0 && entry.item.IsExpired() {
+		c.remove(e)
+		return nil
+	}
+
+	c.order.MoveToFront(e)
+
+	return entry.item.Value
+}
+
+func (c *LRUCache) Has(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, gotit := c.items[key]
+
+	if !gotit {
+		return false
+	}
+
+	item := e.Value.(*lruEntry).item
+
+	return item.Maxage == 0 || !item.IsExpired()
+}
+
+func (c *LRUCache) Del(key string) interface{} {
+	item := c.Get(key)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, gotit := c.items[key]; gotit {
+		c.remove(e)
+	}
+
+	return item
+}
+
+func (c *LRUCache) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.items = make(map[string]*list.Element, c.size)
+	c.order.Init()
+}
+
+// Returns the number of items in the cache, including expired ones which have not been dropped yet.
+func (c *LRUCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.order.Len()
+}
+
+// Must be called with the mutex held.
+func (c *LRUCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.items, e.Value.(*lruEntry).key)
+}
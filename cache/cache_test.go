@@ -25,3 +25,37 @@ func TestItemExpiry(t *testing.T) {
 	assert.False(t, cache.Has("item"))
 	assert.Nil(t, cache.Get("item"))
 }
+
+func TestLRUEviction(t *testing.T) {
+	lru := NewLRUCache(2)
+
+	lru.Set("a", Item{Value: 1})
+	lru.Set("b", Item{Value: 2})
+
+	// Touch "a", so that "b" is the least recently used
+	assert.Equal(t, 1, lru.Get("a"))
+
+	lru.Set("c", Item{Value: 3})
+
+	assert.Equal(t, 2, lru.Len())
+	assert.True(t, lru.Has("a"))
+	assert.False(t, lru.Has("b"))
+	assert.Equal(t, 3, lru.Del("c"))
+	assert.False(t, lru.Has("c"))
+
+	lru.Purge()
+	assert.Equal(t, 0, lru.Len())
+}
+
+func TestLRUExpiry(t *testing.T) {
+	var lru Cache = NewLRUCache(10)
+
+	lru.Set("forever", Item{Value: 1})
+	lru.Set("item", Item{Value: 2, Maxage: 50 * time.Millisecond})
+
+	time.Sleep(100 * time.Millisecond)
+
+	assert.True(t, lru.Has("forever"))
+	assert.False(t, lru.Has("item"))
+	assert.Nil(t, lru.Get("item"))
+}
@@ -0,0 +1,186 @@
+/*
+	kuro-hash makes password hashes for the [users] section of an IniRealm, and checks passwords
+	against them.
+
+		kuro-hash [-format shiro1|pbkdf2|crypt|hashed] [-algorithm name] [-iterations n]
+		kuro-hash -verify hash
+
+	The password is read from the terminal, or else from the first line of the standard input.
+	The hash is written to the standard output.  With -verify, the exit status is 0 if the
+	password matches, and 1 if it does not.
+
+	The "hashed" format is for credential.Hashed matchers.  Unlike the others, it does not
+	carry its parameters, so -verify needs the same -format, -algorithm and -iterations which
+	it was made with.
+*/
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jalkanen/kuro/authc"
+	"github.com/jalkanen/kuro/authc/credential"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+)
+
+// Reads a password, giving the prompt if it asks the user.
+type passwordReader func(prompt string) ([]byte, error)
+
+func main() {
+	os.Exit(run(os.Args[1:], stdinReader(os.Stdin, os.Stderr), os.Stdout, os.Stderr))
+}
+
+// Runs the command, and returns the exit status.
+func run(args []string, readPassword passwordReader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("kuro-hash", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	format := flags.String("format", credential.FormatShiro1, "the hash format: shiro1, pbkdf2, crypt or hashed")
+	algorithm := flags.String("algorithm", "", "the hash algorithm, e.g. SHA-256 or SHA-512 (default depends on the format)")
+	iterations := flags.Int("iterations", 0, "the number of iterations, or rounds for crypt (default depends on the format)")
+	verify := flags.String("verify", "", "check the password against this hash instead of making one")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() > 0 {
+		fmt.Fprintf(stderr, "Unexpected arguments: %s\n", strings.Join(flags.Args(), " "))
+		return 2
+	}
+
+	encoder, err := credential.NewPasswordEncoder(*format, *algorithm, *iterations)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if *verify != "" {
+		return verifyPassword(*verify, encoder, readPassword, stdout, stderr)
+	}
+
+	password, err := readNewPassword(readPassword)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	hash, err := encoder.Encode(password)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	fmt.Fprintln(stdout, hash)
+
+	return 0
+}
+
+// Checks the password against the stored hash.  Hashes of Hashed matchers are checked with
+// the matcher, and the rest by their own parameters.
+func verifyPassword(stored string, encoder credential.PasswordEncoder, readPassword passwordReader, stdout, stderr io.Writer) int {
+	acct := authc.NewAccount("", stored, "")
+
+	if _, ok := encoder.(*credential.Hashed); ok {
+		salt, hash, err := credential.DecodeSaltedHash(stored)
+
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+
+		acct = authc.NewAccount("", hash, "")
+		acct.SetCredentialsSalt(salt)
+	} else if _, err := credential.ParsePasswordHash(stored); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	} else {
+		encoder = credential.NewModularCrypt()
+	}
+
+	password, err := readPassword("Password: ")
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if !encoder.Match(authc.NewToken("", string(password)), acct) {
+		fmt.Fprintln(stdout, "Password does not match")
+		return 1
+	}
+
+	fmt.Fprintln(stdout, "Password matches")
+
+	return 0
+}
+
+// Reads the password, and if the user is asked, asks again to catch typos.
+func readNewPassword(readPassword passwordReader) ([]byte, error) {
+	password, err := readPassword("Password: ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(password) == 0 {
+		return nil, errors.New("Empty password")
+	}
+
+	again, err := readPassword("Again: ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if again != nil && string(again) != string(password) {
+		return nil, errors.New("The passwords do not match")
+	}
+
+	return password, nil
+}
+
+/*
+	Returns a passwordReader which prompts on the terminal without echoing, if the input is a
+	terminal, and otherwise reads the first line.  When reading a line, a repeated read returns
+	nil, as there is nothing to compare with.
+*/
+func stdinReader(in *os.File, prompts io.Writer) passwordReader {
+	if !term.IsTerminal(int(in.Fd())) {
+		return lineReader(in)
+	}
+
+	return func(prompt string) ([]byte, error) {
+		fmt.Fprint(prompts, prompt)
+		defer fmt.Fprintln(prompts)
+
+		return term.ReadPassword(int(in.Fd()))
+	}
+}
+
+func lineReader(in io.Reader) passwordReader {
+	read := false
+
+	return func(prompt string) ([]byte, error) {
+		if read {
+			return nil, nil
+		}
+		read = true
+
+		line, err := bufio.NewReader(in).ReadString('\n')
+
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, errors.New("Could not read a password from the standard input")
+		}
+
+		return []byte(strings.TrimRight(line, "\r\n")), nil
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"github.com/jalkanen/kuro/authc"
+	"github.com/jalkanen/kuro/authc/credential"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+// Runs the command with the input, and returns the exit status and the output.
+func runWith(input string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+
+	status := run(args, lineReader(strings.NewReader(input)), &stdout, &stderr)
+
+	return status, strings.TrimSpace(stdout.String()), stderr.String()
+}
+
+// Answers the prompts in turn, as a user at a terminal would.
+func typed(answers ...string) passwordReader {
+	return func(prompt string) ([]byte, error) {
+		answer := answers[0]
+		answers = answers[1:]
+		return []byte(answer), nil
+	}
+}
+
+func TestHashRoundTrip(t *testing.T) {
+	for _, args := range [][]string{
+		{"-iterations", "10"},
+		{"-format", "pbkdf2", "-algorithm", "SHA-512", "-iterations", "10"},
+		{"-format", "crypt", "-algorithm", "SHA-256"},
+		{"-format", "hashed", "-algorithm", "SHA-512", "-iterations", "3"},
+	} {
+		status, hash, errs := runWith("secret\n", args...)
+		require.Equal(t, 0, status, errs)
+
+		status, out, _ := runWith("secret", append(args, "-verify", hash)...)
+		assert.Equal(t, 0, status)
+		assert.Equal(t, "Password matches", out)
+
+		status, _, _ = runWith("wrong\n", append(args, "-verify", hash)...)
+		assert.Equal(t, 1, status)
+	}
+
+	_, hash, _ := runWith("secret\n", "-format", "pbkdf2", "-iterations", "10")
+	assert.True(t, strings.HasPrefix(hash, "$pbkdf2-sha256$10$"), hash)
+
+	acct := authc.NewAccount("foo", hash, "test")
+	assert.True(t, credential.NewModularCrypt().Match(authc.NewToken("foo", "secret"), acct))
+
+	// A hash of another format carries its own parameters
+	status, _, _ := runWith("secret\n", "-format", "crypt", "-verify", hash)
+	assert.Equal(t, 0, status)
+
+	_, hash, _ = runWith("secret\n", "-format", "hashed", "-iterations", "3")
+	status, _, _ = runWith("secret\n", "-format", "hashed", "-iterations", "4", "-verify", hash)
+	assert.Equal(t, 1, status, "A hashed hash must be checked with the same parameters")
+}
+
+func TestHashPrompt(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	assert.Equal(t, 0, run([]string{"-iterations", "1"}, typed("secret", "secret"), &stdout, &stderr))
+	assert.True(t, strings.HasPrefix(stdout.String(), "$shiro1$SHA-256$1$"))
+
+	stdout.Reset()
+	assert.Equal(t, 1, run(nil, typed("secret", "secert"), &stdout, &stderr))
+	assert.Empty(t, stdout.String())
+}
+
+func TestHashErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"-format", "md5"},
+		{"-algorithm", "SHA-3"},
+		{"-iterations", "-1"},
+		{"-verify", "plaintext"},
+		{"extra"},
+		{"-nosuchflag"},
+	} {
+		status, out, _ := runWith("secret\n", args...)
+		assert.Equal(t, 2, status, "%v", args)
+		assert.Empty(t, out)
+	}
+
+	status, _, errs := runWith("")
+	assert.Equal(t, 1, status)
+	assert.NotEmpty(t, errs)
+}
@@ -14,4 +14,16 @@ func Example() {
 		log.Printf("Is Subject authenticated? %s\n", subject.IsAuthenticated())
 	})
 	log.Fatal(http.ListenAndServe(":6999", nil))
-}
\ No newline at end of file
+}
+
+// Displays how to bind the Subject to the request context, so that it can be found
+// anywhere the context is passed.
+func ExampleMiddleware() {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject := kuro.FromContext(r.Context())
+
+		log.Printf("Is Subject authenticated? %t\n", subject.IsAuthenticated())
+	})
+
+	log.Fatal(http.ListenAndServe(":6999", kuro.Middleware(handler)))
+}
@@ -0,0 +1,182 @@
+package kuro
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jalkanen/kuro/authz"
+	"github.com/jalkanen/kuro/realm"
+)
+
+/*
+	An Explanation tells why a permission check came out the way it did.  It is meant for
+	debugging and for admin pages ("why can Bob do this?"), not for making decisions.
+*/
+type Explanation struct {
+	Principals []interface{}
+	Permission string
+
+	// The decision, after the AuthorizationPolicy has combined the votes of the realms.
+	Permitted bool
+
+	// Set if the permission could not be parsed, in which case nothing is permitted.
+	Err error
+
+	// One entry per realm, in the order the realms were consulted.
+	Realms []RealmExplanation
+}
+
+// RealmExplanation describes the part a single realm played in a decision.
+type RealmExplanation struct {
+	Realm string
+	Vote  Vote
+
+	// The roles of the principals in this realm, inherited ones included if the realm tells them.
+	Roles []string
+
+	// The granted permission which implied the requested one, or nil if none did.
+	GrantedBy authz.Permission
+
+	// The DenyPermission which denied the requested one, or nil if none did.
+	DeniedBy authz.Permission
+
+	// The error the realm returned for AuthorizationInfo().  Such errors do not stop the
+	// check; the realm simply abstains.
+	Err error
+}
+
+// Realms which can tell what their decisions are based on, such as realm.SimpleAccountRealm.
+type grantingRealm interface {
+	Grants(principals []interface{}) (*authz.Grants, error)
+}
+
+func (v Vote) String() string {
+	switch v {
+	case Grant:
+		return "Grant"
+	case Deny:
+		return "Deny"
+	}
+	return "Abstain"
+}
+
+// Explains the decision the SecurityManager makes for the permission.
+func (sm *DefaultSecurityManager) Explain(principals []interface{}, permission string) *Explanation {
+	e := &Explanation{
+		Principals: principals,
+		Permission: permission,
+	}
+
+	p, err := authz.ResolvePermission(sm.permissionResolver, permission)
+
+	if err != nil {
+		e.Err = err
+		return e
+	}
+
+	sm.authorizer().explain(e, p)
+
+	return e
+}
+
+/*
+	Fills in the Explanation by asking each realm in the same way as IsPermittedP() does.  The
+	votes, roles and permissions come from the same authz.Grants as the decisions do.  An
+	authz.Authorizer which cannot tell its Grants only gives its vote.
+*/
+func (a *ModularRealmAuthorizer) explain(e *Explanation, permission authz.Permission) {
+	if len(e.Principals) == 0 {
+		return
+	}
+
+	votes := make([]Vote, 0, len(a.realms))
+
+	for _, re := range a.realms {
+		x := a.explainRealm(re, e.Principals, permission)
+
+		votes = append(votes, x.Vote)
+		e.Realms = append(e.Realms, x)
+	}
+
+	e.Permitted = a.Policy.Decide(votes)
+}
+
+func (a *ModularRealmAuthorizer) explainRealm(re realm.Realm, principals []interface{}, permission authz.Permission) RealmExplanation {
+	x := RealmExplanation{Realm: re.Name(), Vote: Abstain}
+
+	var g *authz.Grants
+
+	switch r := re.(type) {
+	case grantingRealm:
+		g, x.Err = r.Grants(principals)
+	case authz.Authorizer:
+		if answers, known := a.ask(re, principals,
+			func(r authz.Authorizer) []bool {
+				return []bool{r.IsPermittedP(principals, permission)}
+			}, nil); known {
+			x.Vote = voteOf(answers[0])
+		}
+	case realm.AuthorizingRealm:
+		info, err := r.AuthorizationInfo(principals)
+		x.Err = err
+
+		if info != nil {
+			g = a.grants(info)
+		}
+	}
+
+	if g != nil {
+		x.Vote = voteOf(g.Permits(permission))
+		x.Roles = g.Roles
+		x.GrantedBy = g.GrantedBy(permission)
+		x.DeniedBy = g.DeniedBy(permission)
+	}
+
+	return x
+}
+
+func voteOf(granted bool) Vote {
+	if granted {
+		return Grant
+	}
+	return Deny
+}
+
+// Returns a human-readable, multi-line version of the Explanation.
+func (e *Explanation) String() string {
+	var buf bytes.Buffer
+
+	decision := "denied"
+	if e.Permitted {
+		decision = "permitted"
+	}
+
+	fmt.Fprintf(&buf, "Permission '%s' for %v: %s\n", e.Permission, e.Principals, decision)
+
+	if e.Err != nil {
+		fmt.Fprintf(&buf, "  invalid permission: %s\n", e.Err)
+	}
+
+	for _, x := range e.Realms {
+		fmt.Fprintf(&buf, "  realm %s: %s", x.Realm, x.Vote)
+
+		if len(x.Roles) > 0 {
+			fmt.Fprintf(&buf, ", roles %v", x.Roles)
+		}
+
+		if x.GrantedBy != nil {
+			fmt.Fprintf(&buf, ", granted by '%s'", x.GrantedBy)
+		}
+
+		if x.DeniedBy != nil {
+			fmt.Fprintf(&buf, ", denied by '%s'", x.DeniedBy)
+		}
+
+		if x.Err != nil {
+			fmt.Fprintf(&buf, ", error: %s", x.Err)
+		}
+
+		buf.WriteString("\n")
+	}
+
+	return buf.String()
+}
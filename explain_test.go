@@ -0,0 +1,131 @@
+package kuro
+
+import (
+	"github.com/jalkanen/kuro/authz"
+	"github.com/jalkanen/kuro/realm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func newExplainingManager(t *testing.T) *DefaultSecurityManager {
+	msm := newSecurityManager()
+
+	staff, err := realm.NewIni("staff", strings.NewReader(`
+  [users]
+  bob = password, admin
+
+  [roles]
+  editor = documents:*, !documents:*:delete
+  admin = @editor, users:*
+`))
+	require.NoError(t, err)
+
+	others, err := realm.NewIni("others", strings.NewReader(`
+  [users]
+  alice = password
+`))
+	require.NoError(t, err)
+
+	msm.SetRealm(staff)
+	msm.AddRealm(others)
+
+	return msm
+}
+
+func TestExplain(t *testing.T) {
+	msm := newExplainingManager(t)
+	bob := []interface{}{"bob"}
+
+	e := msm.Explain(bob, "documents:12:read")
+
+	assert.True(t, e.Permitted)
+	assert.Nil(t, e.Err)
+	require.Equal(t, 2, len(e.Realms))
+
+	assert.Equal(t, "staff", e.Realms[0].Realm)
+	assert.Equal(t, Grant, e.Realms[0].Vote)
+	assert.Equal(t, []string{"admin", "editor"}, e.Realms[0].Roles)
+	assert.Equal(t, "documents:*", e.Realms[0].GrantedBy.String())
+	assert.Nil(t, e.Realms[0].DeniedBy)
+	assert.Nil(t, e.Realms[0].Err)
+
+	assert.Equal(t, "others", e.Realms[1].Realm)
+	assert.Equal(t, Abstain, e.Realms[1].Vote)
+	assert.Equal(t, realm.ErrUnknownAccount, e.Realms[1].Err)
+
+	e = msm.Explain(bob, "documents:12:delete")
+
+	assert.False(t, e.Permitted)
+	assert.Equal(t, Deny, e.Realms[0].Vote)
+	assert.Equal(t, "documents:*", e.Realms[0].GrantedBy.String())
+	assert.Equal(t, "!documents:*:delete", e.Realms[0].DeniedBy.String())
+	assert.Contains(t, e.String(), "realm staff: Deny")
+	assert.Contains(t, e.String(), "denied by '!documents:*:delete'")
+	assert.Contains(t, e.String(), "realm others: Abstain, error: Unknown account")
+
+	e = msm.Explain(bob, "printers:print")
+
+	assert.False(t, e.Permitted)
+	assert.Equal(t, Deny, e.Realms[0].Vote)
+	assert.Nil(t, e.Realms[0].GrantedBy)
+
+	e = msm.Explain(bob, ":::")
+
+	assert.False(t, e.Permitted)
+	assert.Error(t, e.Err)
+
+	e = msm.Explain(nil, "documents:12:read")
+
+	assert.False(t, e.Permitted)
+	assert.Empty(t, e.Realms)
+}
+
+func TestSubjectExplain(t *testing.T) {
+	msm := newExplainingManager(t)
+
+	subject, _ := msm.CreateSubject(&SubjectContext{
+		Authenticated: true,
+		Principals:    []interface{}{"bob"},
+	})
+
+	e := subject.Explain("users:create")
+
+	assert.Equal(t, subject.IsPermitted("users:create"), e.Permitted)
+	assert.Equal(t, "users:*", e.Realms[0].GrantedBy.String())
+}
+
+func TestExplainAgreesWithDecision(t *testing.T) {
+	msm := newExplainingManager(t)
+	cr := &countingRealm{}
+	msm.AddRealm(cr)
+
+	// Only realms which are not Authorizers get the roles resolved by the SecurityManager
+	msm.SetRolePermissionResolver(authz.RolePermissionResolverFunc(func(role string) []authz.Permission {
+		p, _ := authz.ResolvePermission(nil, "printers:*")
+		return []authz.Permission{p}
+	}))
+
+	bob := []interface{}{"bob"}
+
+	for _, permission := range []string{"printers:manage", "documents:12:delete", "users:create"} {
+		e := msm.Explain(bob, permission)
+		require.Equal(t, 3, len(e.Realms))
+
+		assert.Equal(t, msm.IsPermitted(bob, permission), e.Permitted, permission)
+		assert.Equal(t, voteOf(msm.realms[0].(authz.Authorizer).IsPermitted(bob, permission)), e.Realms[0].Vote, permission)
+	}
+
+	e := msm.Explain(bob, "printers:manage")
+
+	assert.Equal(t, Deny, e.Realms[0].Vote)
+	assert.Nil(t, e.Realms[0].GrantedBy, "The staff realm does not use the resolver")
+	assert.Equal(t, Grant, e.Realms[2].Vote)
+	assert.Equal(t, []string{"printer"}, e.Realms[2].Roles)
+	assert.Equal(t, "printers:*", e.Realms[2].GrantedBy.String())
+
+	calls := cr.calls
+	msm.Explain(bob, "printers:manage")
+	assert.Equal(t, calls+1, cr.calls, "The info is resolved once")
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"github.com/jalkanen/kuro"
+	"github.com/jalkanen/kuro/authc"
+	"net/http"
+	"strings"
+)
+
+/*
+	BasicAuth logs in Subjects using the credentials from the HTTP Basic "Authorization" header.
+	If there are no credentials or they are incorrect, a 401 with a WWW-Authenticate challenge
+	for RealmName is sent.
+
+	In Stateless mode, every request is authenticated on its own, and the Subject is never given
+	a Session.  This is usually what you want for API clients, since they would otherwise
+	create a new Session through the SessionManager on each request.
+*/
+type BasicAuth struct {
+	// The realm name sent to the client in the challenge.
+	RealmName string
+
+	// If true, a new Subject without a Session is created and logged in for each request.
+	Stateless bool
+}
+
+// Creates a new, stateful BasicAuth with the given realm name.
+func NewBasicAuth(realmName string) *BasicAuth {
+	return &BasicAuth{
+		RealmName: realmName,
+	}
+}
+
+// Returns a token from the Authorization header, or false if the request does not
+// have Basic credentials.
+func BasicToken(r *http.Request) (*authc.UsernamePasswordToken, bool) {
+	username, password, ok := r.BasicAuth()
+
+	if !ok {
+		return nil, false
+	}
+
+	return authc.NewToken(username, password), true
+}
+
+// Returns an http.Handler which passes only authenticated requests to next.
+func (b *BasicAuth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var subject kuro.Subject
+
+		if b.Stateless {
+			subject, _ = kuro.Manager.CreateSubject(&kuro.SubjectContext{
+				Request:        r,
+				ResponseWriter: w,
+			})
+		} else {
+			subject = kuro.Get(r, w)
+			defer kuro.Finish(r)
+		}
+
+		r = r.WithContext(kuro.NewContext(r.Context(), subject))
+
+		if b.authenticate(w, r, subject) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Logs the Subject in, unless it is already authenticated.  Sends the challenge and
+// returns false if that cannot be done.
+func (b *BasicAuth) authenticate(w http.ResponseWriter, r *http.Request, subject kuro.Subject) bool {
+	if subject.IsAuthenticated() {
+		return true
+	}
+
+	if token, ok := BasicToken(r); ok {
+		if err := subject.Login(token); err == nil {
+			return true
+		}
+	}
+
+	b.challenge(w)
+	return false
+}
+
+func (b *BasicAuth) challenge(w http.ResponseWriter) {
+	realm := strings.Replace(b.RealmName, `"`, `\"`, -1)
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
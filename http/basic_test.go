@@ -0,0 +1,58 @@
+package http
+
+import (
+	"fmt"
+	"github.com/jalkanen/kuro"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	b := NewBasicAuth(`My "API"`)
+	b.Stateless = true
+
+	var subject kuro.Subject
+
+	h := b.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = kuro.Get(r, w)
+	}))
+
+	req, _ := http.NewRequest("GET", "/api", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="My \"API\""`, w.Header().Get("WWW-Authenticate"))
+
+	req.SetBasicAuth("foo", "wrong")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Nil(t, subject)
+
+	req.SetBasicAuth("foo", "password")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, subject.IsAuthenticated())
+	assert.Equal(t, "foo", subject.Principal().(fmt.Stringer).String())
+	assert.Nil(t, subject.Session(), "Stateless requests must not create sessions")
+}
+
+func TestBasicToken(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api", nil)
+
+	_, ok := BasicToken(req)
+	assert.False(t, ok)
+
+	req.SetBasicAuth("foo", "pass:word")
+	token, ok := BasicToken(req)
+
+	assert.True(t, ok)
+	assert.Equal(t, "foo", token.Username())
+	assert.Equal(t, []byte("pass:word"), token.Credentials())
+}
@@ -0,0 +1,328 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jalkanen/kuro"
+	"net/http"
+	"strings"
+)
+
+/*
+	A Filter inspects each request which matches a filter chain before it reaches the application.
+	The config contains the values given in brackets in the chain definition, so for
+	"roles[admin,editor]" the config is []string{"admin", "editor"}.  Filters without a
+	bracketed value receive an empty config.
+
+	Return true to let the request proceed down the chain.  If the Filter returns false,
+	it must already have written a response, and no further filters or handlers are run.
+*/
+type Filter interface {
+	Filter(w http.ResponseWriter, r *http.Request, subject kuro.Subject, config []string) bool
+}
+
+// FilterFunc allows an ordinary function to be used as a Filter.
+type FilterFunc func(w http.ResponseWriter, r *http.Request, subject kuro.Subject, config []string) bool
+
+func (f FilterFunc) Filter(w http.ResponseWriter, r *http.Request, subject kuro.Subject, config []string) bool {
+	return f(w, r, subject, config)
+}
+
+type filterRef struct {
+	name   string
+	filter Filter
+	config []string
+}
+
+type chain struct {
+	pattern string
+	filters []filterRef
+}
+
+/*
+	FilterChainManager maps URL path patterns to ordered chains of Filters.  Its Handler() method
+	wraps your application so that every request is checked against the chains before it gets through.
+
+	The following filters are available by default:
+
+		anon          Always lets the request through.
+		authc         Requires an authenticated Subject.
+		authcBasic    Requires an authenticated Subject, logging in with HTTP Basic credentials if needed.
+		user          Requires either an authenticated or a remembered Subject.
+		roles[a,b]    Requires the Subject to have all of the given roles.
+		perms[a,b]    Requires the Subject to have all of the given permissions.
+		logout        Logs the Subject out and redirects to LogoutRedirectURL.
+
+	Chains are matched in the order they were added, and only the first matching chain is run.
+	Requests which do not match any chain go straight to the application.
+
+	The FilterChainManager should be fully configured before it starts serving requests.
+*/
+type FilterChainManager struct {
+	// Where unauthenticated users are redirected.  If empty, a 401 is sent instead.
+	LoginURL string
+
+	// Where users who lack a required role or permission are redirected.  If empty, a 403 is sent instead.
+	UnauthorizedURL string
+
+	// Where the logout filter redirects to.  Defaults to "/".
+	LogoutRedirectURL string
+
+	// Used by the authcBasic filter.  Defaults to a BasicAuth with the realm name "application".
+	// Note that the Stateless setting has no effect here, since the chain has already acquired
+	// the Subject; use BasicAuth.Handler() directly for stateless APIs.
+	BasicAuth *BasicAuth
+
+	filters map[string]Filter
+	chains  []chain
+}
+
+// Creates a new FilterChainManager with all the default filters registered, but no chains.
+func NewFilterChainManager() *FilterChainManager {
+	m := &FilterChainManager{
+		LogoutRedirectURL: "/",
+		BasicAuth:         NewBasicAuth("application"),
+		filters:           make(map[string]Filter, 8),
+	}
+
+	m.AddFilter("anon", FilterFunc(m.anon))
+	m.AddFilter("authc", FilterFunc(m.authc))
+	m.AddFilter("authcBasic", FilterFunc(m.authcBasic))
+	m.AddFilter("user", FilterFunc(m.user))
+	m.AddFilter("roles", FilterFunc(m.roles))
+	m.AddFilter("perms", FilterFunc(m.perms))
+	m.AddFilter("logout", FilterFunc(m.logout))
+
+	return m
+}
+
+// Registers a Filter under the given name, replacing any previous Filter of the same name.
+// Filters must be added before they are referred to in AddChain().
+func (m *FilterChainManager) AddFilter(name string, f Filter) {
+	m.filters[name] = f
+}
+
+/*
+	Adds a new filter chain for the given Ant-style path pattern.  The definition is a comma-separated
+	list of filter names, e.g. "authc, roles[admin]".  Values which themselves contain commas can be
+	quoted, e.g. `perms["printers:print,query"]`.
+*/
+func (m *FilterChainManager) AddChain(pattern string, definition string) error {
+	defs, err := splitOutside(definition)
+
+	if err != nil {
+		return err
+	}
+
+	c := chain{pattern: pattern}
+
+	for _, def := range defs {
+		ref, err := m.parseFilter(def)
+
+		if err != nil {
+			return err
+		}
+
+		c.filters = append(c.filters, ref)
+	}
+
+	m.chains = append(m.chains, c)
+
+	return nil
+}
+
+// Returns an http.Handler which runs the matching filter chain before passing the
+// request to next.  Unless the request context already has a Subject, it is acquired with
+// kuro.Get() and released with kuro.Finish(), and is also bound to the request context, so
+// it can be found with kuro.FromContext().
+func (m *FilterChainManager) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := m.match(r.URL.Path)
+
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		subject, r, release := requestSubject(w, r)
+		defer release()
+
+		for _, f := range c.filters {
+			if !f.filter.Filter(w, r, subject, f.config) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *FilterChainManager) match(p string) *chain {
+	for i := range m.chains {
+		if matchPath(m.chains[i].pattern, p) {
+			return &m.chains[i]
+		}
+	}
+
+	return nil
+}
+
+// Parses a single "name" or "name[config]" definition.
+func (m *FilterChainManager) parseFilter(def string) (filterRef, error) {
+	name := def
+	var config []string
+
+	if i := strings.Index(def, "["); i >= 0 {
+		if !strings.HasSuffix(def, "]") {
+			return filterRef{}, fmt.Errorf("Filter definition '%s' is missing a closing bracket", def)
+		}
+
+		name = strings.TrimSpace(def[:i])
+		vals, err := splitOutside(def[i+1 : len(def)-1])
+
+		if err != nil {
+			return filterRef{}, err
+		}
+
+		for _, v := range vals {
+			config = append(config, strings.Trim(v, `"`))
+		}
+	}
+
+	f, ok := m.filters[name]
+
+	if !ok {
+		return filterRef{}, fmt.Errorf("Unknown filter '%s'", name)
+	}
+
+	return filterRef{name: name, filter: f, config: config}, nil
+}
+
+// Splits the string at commas which are not inside brackets or quotes, and trims the results.
+// Empty values are dropped.
+func splitOutside(s string) ([]string, error) {
+	var res []string
+	depth := 0
+	quoted := false
+	start := 0
+
+	add := func(v string) {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			add(s[start:i])
+			start = i + 1
+		}
+	}
+
+	if quoted || depth != 0 {
+		return nil, errors.New("Unbalanced brackets or quotes in filter definition: " + s)
+	}
+
+	add(s[start:])
+
+	return res, nil
+}
+
+// Sends the user to the login page, or a 401 if there is none.  The original request is
+// saved in the Subject's Session so that FormLogin can return the user to it later.
+func (m *FilterChainManager) loginRequired(w http.ResponseWriter, r *http.Request, subject kuro.Subject) {
+	if m.LoginURL != "" {
+		SaveRequest(subject, r)
+		http.Redirect(w, r, m.LoginURL, http.StatusFound)
+		return
+	}
+
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// Sends the user to the unauthorized page, or a 403 if there is none.
+func (m *FilterChainManager) unauthorized(w http.ResponseWriter, r *http.Request) {
+	if m.UnauthorizedURL != "" {
+		http.Redirect(w, r, m.UnauthorizedURL, http.StatusFound)
+		return
+	}
+
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+/*
+	Default filters
+*/
+
+func (m *FilterChainManager) anon(w http.ResponseWriter, r *http.Request, subject kuro.Subject, config []string) bool {
+	return true
+}
+
+func (m *FilterChainManager) authc(w http.ResponseWriter, r *http.Request, subject kuro.Subject, config []string) bool {
+	if subject.IsAuthenticated() {
+		return true
+	}
+
+	m.loginRequired(w, r, subject)
+	return false
+}
+
+func (m *FilterChainManager) authcBasic(w http.ResponseWriter, r *http.Request, subject kuro.Subject, config []string) bool {
+	return m.BasicAuth.authenticate(w, r, subject)
+}
+
+func (m *FilterChainManager) user(w http.ResponseWriter, r *http.Request, subject kuro.Subject, config []string) bool {
+	if subject.IsAuthenticated() || subject.IsRemembered() {
+		return true
+	}
+
+	m.loginRequired(w, r, subject)
+	return false
+}
+
+func (m *FilterChainManager) roles(w http.ResponseWriter, r *http.Request, subject kuro.Subject, config []string) bool {
+	if subject.Principal() == nil {
+		m.loginRequired(w, r, subject)
+		return false
+	}
+
+	for _, role := range config {
+		if !subject.HasRole(role) {
+			m.unauthorized(w, r)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *FilterChainManager) perms(w http.ResponseWriter, r *http.Request, subject kuro.Subject, config []string) bool {
+	if subject.Principal() == nil {
+		m.loginRequired(w, r, subject)
+		return false
+	}
+
+	for _, perm := range config {
+		if !subject.IsPermitted(perm) {
+			m.unauthorized(w, r)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *FilterChainManager) logout(w http.ResponseWriter, r *http.Request, subject kuro.Subject, config []string) bool {
+	subject.Logout()
+
+	http.Redirect(w, r, m.LogoutRedirectURL, http.StatusFound)
+	return false
+}
@@ -0,0 +1,145 @@
+package http
+
+import (
+	"github.com/jalkanen/kuro"
+	"github.com/jalkanen/kuro/authc"
+	"github.com/jalkanen/kuro/realm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var ini string = `
+  [users]
+  foo = password, manager
+  bar = password2, admin
+
+  [roles]
+  admin = *
+  manager = printers:print
+`
+
+func init() {
+	r, _ := realm.NewIni("ini", strings.NewReader(ini))
+	kuro.Manager.SetRealm(r)
+}
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+})
+
+// Runs the request, optionally logging in the given user first.
+func serve(h http.Handler, url string, username, password string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+
+	if username != "" {
+		subject, _ := kuro.Manager.CreateSubject(&kuro.SubjectContext{CreateSessions: true})
+		subject.Login(authc.NewToken(username, password))
+		kuro.With(req, subject)
+	}
+
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestMatchPath(t *testing.T) {
+	assert.True(t, matchPath("/**", "/"))
+	assert.True(t, matchPath("/**", "/foo/bar"))
+	assert.True(t, matchPath("/admin/**", "/admin"))
+	assert.True(t, matchPath("/admin/**", "/admin/users/12"))
+	assert.False(t, matchPath("/admin/**", "/administrator"))
+	assert.True(t, matchPath("/admin/*", "/admin/users"))
+	assert.False(t, matchPath("/admin/*", "/admin/users/12"))
+	assert.True(t, matchPath("/**/*.html", "/foo/bar/index.html"))
+	assert.False(t, matchPath("/**/*.html", "/foo/bar/index.css"))
+	assert.True(t, matchPath("/docs/?", "/docs/a"))
+	assert.True(t, matchPath("/login", "/login"))
+}
+
+func TestSplitOutside(t *testing.T) {
+	vals, err := splitOutside(`authc, roles[admin, editor], perms["printers:print,query"]`)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"authc", "roles[admin, editor]", `perms["printers:print,query"]`}, vals)
+
+	_, err = splitOutside("roles[admin")
+	assert.Error(t, err)
+}
+
+func TestAddChainErrors(t *testing.T) {
+	m := NewFilterChainManager()
+
+	assert.Error(t, m.AddChain("/**", "nosuchfilter"))
+	assert.Error(t, m.AddChain("/**", "roles[admin"))
+	assert.NoError(t, m.AddChain("/**", `authc, perms["printers:print,query"]`))
+	assert.Equal(t, []string{"printers:print,query"}, m.chains[0].filters[1].config)
+}
+
+func TestFilterChain(t *testing.T) {
+	m := NewFilterChainManager()
+	m.LoginURL = "/login"
+
+	require.NoError(t, m.AddChain("/login", "anon"))
+	require.NoError(t, m.AddChain("/logout", "logout"))
+	require.NoError(t, m.AddChain("/admin/**", "authc, roles[admin]"))
+	require.NoError(t, m.AddChain("/print/**", "authc, perms[printers:print]"))
+	require.NoError(t, m.AddChain("/api/**", "authcBasic"))
+	require.NoError(t, m.AddChain("/**", "user"))
+
+	h := m.Handler(ok)
+
+	w := serve(h, "/login", "", "")
+	assert.Equal(t, 200, w.Code)
+
+	w = serve(h, "/index.html", "", "")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	w = serve(h, "/index.html", "foo", "password")
+	assert.Equal(t, 200, w.Code)
+
+	w = serve(h, "/admin/users", "foo", "password")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serve(h, "/admin/users", "bar", "password2")
+	assert.Equal(t, 200, w.Code)
+
+	w = serve(h, "/print/hp", "foo", "password")
+	assert.Equal(t, 200, w.Code)
+
+	w = serve(h, "/logout", "foo", "password")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+}
+
+func TestFilterChainBasic(t *testing.T) {
+	m := NewFilterChainManager()
+	require.NoError(t, m.AddChain("/api/**", "authcBasic"))
+
+	h := m.Handler(ok)
+
+	w := serve(h, "/api/foo", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic"))
+
+	req, _ := http.NewRequest("GET", "/api/foo", nil)
+	req.SetBasicAuth("foo", "password")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	req, _ = http.NewRequest("GET", "/api/foo", nil)
+	req.SetBasicAuth("foo", "wrong")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Unmatched paths go straight through
+	w = serve(h, "/public", "", "")
+	assert.Equal(t, 200, w.Code)
+}
@@ -0,0 +1,170 @@
+package http
+
+import (
+	"github.com/jalkanen/kuro"
+	"github.com/jalkanen/kuro/authc"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// The Session key under which the URL of the request which was interrupted by a login is stored.
+	SavedRequestKey = "__savedrequest"
+
+	// Failure reasons passed to the login page.
+	FailureMissingCredentials   = "missing"
+	FailureIncorrectCredentials = "incorrect"
+)
+
+/*
+	FormLogin is an http.Handler which processes login forms.  On a POST it reads the username,
+	password and rememberMe form values, and logs in the current Subject.
+
+	On success the user is redirected to the request which was saved when they were sent to
+	the login page (see SaveRequest), or to SuccessURL if there is none.  On failure the user is
+	redirected back to LoginURL, with the failure reason in the FailureParam query parameter,
+	e.g. "/login?loginFailure=incorrect".
+
+	Requests other than POSTs are passed to LoginPage, which would typically render the form.
+*/
+type FormLogin struct {
+	// The URL of the login page.
+	LoginURL string
+
+	// Where the user goes after login if there is no saved request.  Defaults to "/".
+	SuccessURL string
+
+	// Renders the login form for GET requests.  If nil, non-POST requests get a 405.
+	LoginPage http.Handler
+
+	// Names of the form values.  Default to "username", "password" and "rememberMe".
+	UsernameParam   string
+	PasswordParam   string
+	RememberMeParam string
+
+	// The name of the query parameter which carries the failure reason to the login page.
+	// Defaults to "loginFailure".
+	FailureParam string
+}
+
+// Creates a new FormLogin handler with the default parameter names.
+func NewFormLogin(loginURL string, successURL string) *FormLogin {
+	return &FormLogin{
+		LoginURL:        loginURL,
+		SuccessURL:      successURL,
+		UsernameParam:   "username",
+		PasswordParam:   "password",
+		RememberMeParam: "rememberMe",
+		FailureParam:    "loginFailure",
+	}
+}
+
+func (f *FormLogin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		if f.LoginPage != nil {
+			f.LoginPage.ServeHTTP(w, r)
+		} else {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	subject := kuro.Get(r, w)
+	defer kuro.Finish(r)
+
+	username := r.PostFormValue(f.UsernameParam)
+	password := r.PostFormValue(f.PasswordParam)
+
+	if username == "" || password == "" {
+		f.failure(w, r, FailureMissingCredentials)
+		return
+	}
+
+	token := authc.NewTokenRemember(username, password, isTrue(r.PostFormValue(f.RememberMeParam)))
+
+	if err := subject.Login(token); err != nil {
+		f.failure(w, r, FailureIncorrectCredentials)
+		return
+	}
+
+	target := SavedRequest(subject)
+
+	if target == "" {
+		target = f.SuccessURL
+	}
+
+	if target == "" {
+		target = "/"
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (f *FormLogin) failure(w http.ResponseWriter, r *http.Request, reason string) {
+	u, err := url.Parse(f.LoginURL)
+
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	q := u.Query()
+	q.Set(f.FailureParam, reason)
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// Checkboxes and the like can be submitted in a number of ways.
+func isTrue(val string) bool {
+	switch strings.ToLower(val) {
+	case "on", "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+// Stores the URL of the request in the Subject's Session, so that the user can be returned there
+// after logging in.  Does nothing if the Subject has no Session, or if the URL is not a local path.
+func SaveRequest(subject kuro.Subject, r *http.Request) {
+	u := r.URL.RequestURI()
+
+	if !isLocalPath(u) {
+		return
+	}
+
+	if s := subject.Session(); s != nil {
+		s.Set(SavedRequestKey, u)
+		s.Save()
+	}
+}
+
+// Returns the URL stored with SaveRequest() and removes it from the Session.  Returns an empty
+// string if there is none, or if it is not a local path.
+func SavedRequest(subject kuro.Subject) string {
+	s := subject.Session()
+
+	if s == nil {
+		return ""
+	}
+
+	u, _ := s.Get(SavedRequestKey).(string)
+
+	if u != "" {
+		s.Del(SavedRequestKey)
+		s.Save()
+	}
+
+	if !isLocalPath(u) {
+		return ""
+	}
+
+	return u
+}
+
+// Returns true, if the URL is a path on this server.  Browsers take "//host/path" and
+// "/\host/path" to be other hosts, so redirecting to those would be an open redirect.
+func isLocalPath(u string) bool {
+	return strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//") && !strings.HasPrefix(u, "/\\")
+}
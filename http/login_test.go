@@ -0,0 +1,91 @@
+package http
+
+import (
+	"github.com/jalkanen/kuro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func post(h http.Handler, subject kuro.Subject, values url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	kuro.With(req, subject)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestFormLogin(t *testing.T) {
+	login := NewFormLogin("/login", "/home")
+	subject, _ := kuro.Manager.CreateSubject(&kuro.SubjectContext{CreateSessions: true})
+
+	w := post(login, subject, url.Values{"username": {"foo"}})
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login?loginFailure=missing", w.Header().Get("Location"))
+
+	w = post(login, subject, url.Values{"username": {"foo"}, "password": {"wrong"}})
+	assert.Equal(t, "/login?loginFailure=incorrect", w.Header().Get("Location"))
+	assert.False(t, subject.IsAuthenticated())
+
+	w = post(login, subject, url.Values{"username": {"foo"}, "password": {"password"}})
+	assert.Equal(t, "/home", w.Header().Get("Location"))
+	assert.True(t, subject.IsAuthenticated())
+
+	req, _ := http.NewRequest("GET", "/login", nil)
+	w = httptest.NewRecorder()
+	login.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestSavedRequest(t *testing.T) {
+	m := NewFilterChainManager()
+	m.LoginURL = "/login"
+	require.NoError(t, m.AddChain("/secret/**", "authc"))
+
+	login := NewFormLogin("/login", "/home")
+	subject, _ := kuro.Manager.CreateSubject(&kuro.SubjectContext{CreateSessions: true})
+
+	req, _ := http.NewRequest("GET", "/secret/stuff?page=2", nil)
+	kuro.With(req, subject)
+	w := httptest.NewRecorder()
+	m.Handler(ok).ServeHTTP(w, req)
+
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	w = post(login, subject, url.Values{"username": {"foo"}, "password": {"password"}})
+	assert.Equal(t, "/secret/stuff?page=2", w.Header().Get("Location"))
+
+	// The saved request is only used once
+	assert.Equal(t, "", SavedRequest(subject))
+}
+
+func TestSavedRequestNotLocal(t *testing.T) {
+	m := NewFilterChainManager()
+	m.LoginURL = "/login"
+	require.NoError(t, m.AddChain("/**", "authc"))
+
+	login := NewFormLogin("/login", "/home")
+	subject, _ := kuro.Manager.CreateSubject(&kuro.SubjectContext{CreateSessions: true})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.URL = &url.URL{Path: "//evil.example/x"}
+	kuro.With(req, subject)
+	w := httptest.NewRecorder()
+	m.Handler(ok).ServeHTTP(w, req)
+
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	w = post(login, subject, url.Values{"username": {"foo"}, "password": {"password"}})
+	assert.Equal(t, "/home", w.Header().Get("Location"))
+
+	for _, u := range []string{"/ok?x=1", "//evil.example/x", "/\\evil.example/x", "http://evil.example/x", ""} {
+		assert.Equal(t, strings.HasPrefix(u, "/ok"), isLocalPath(u), u)
+	}
+}
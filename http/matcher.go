@@ -0,0 +1,71 @@
+package http
+
+import (
+	"path"
+	"strings"
+)
+
+/*
+	matchPath tests a request path against an Ant-style pattern, as used by the filter chain
+	definitions.  The pattern is split into slash-separated segments, and
+
+		?  matches exactly one character within a segment
+		*  matches zero or more characters within a segment
+		** matches zero or more whole segments
+
+	So "/admin/**" matches "/admin", "/admin/" and "/admin/users/12", whereas "/admin/*" only
+	matches "/admin/users".
+*/
+func matchPath(pattern, p string) bool {
+	return matchSegments(splitPath(pattern), splitPath(p))
+}
+
+func splitPath(p string) []string {
+	parts := strings.Split(p, "/")
+	segments := make([]string, 0, len(parts))
+
+	for _, s := range parts {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	return segments
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive double wildcards, then try to match the rest of the
+			// pattern at every possible position.
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 0 {
+				return true
+			}
+
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern, segments[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+
+		if ok, err := path.Match(pattern[0], segments[0]); !ok || err != nil {
+			return false
+		}
+
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+
+	return len(segments) == 0
+}
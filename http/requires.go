@@ -0,0 +1,180 @@
+package http
+
+import (
+	"github.com/jalkanen/kuro"
+	"net/http"
+)
+
+// Logical tells how multiple roles or permissions are combined in RequiresRoles() and RequiresPermissions().
+type Logical int
+
+const (
+	// All of the given roles or permissions are required.
+	And Logical = iota
+
+	// Any one of the given roles or permissions is enough.
+	Or
+)
+
+/*
+	A Guard holds what its Requires wrappers do when a check fails.  The functions of this
+	package use a Guard with the defaults; make your own to respond differently, e.g.
+
+		g := &Guard{UnauthorizedHandler: http.RedirectHandler("/denied", http.StatusFound)}
+		mux.Handle("/admin", g.RequiresRoles(And, "admin")(adminPage))
+*/
+type Guard struct {
+	// Called when a Subject is not logged in, but should be.  If nil, a 401 is sent.
+	UnauthenticatedHandler http.Handler
+
+	// Called when a Subject is logged in, but lacks a required role or permission.  If nil,
+	// a 403 is sent.
+	UnauthorizedHandler http.Handler
+}
+
+var defaultGuard = &Guard{}
+
+func (g *Guard) unauthenticated() http.Handler {
+	if g.UnauthenticatedHandler != nil {
+		return g.UnauthenticatedHandler
+	}
+	return statusHandler(http.StatusUnauthorized)
+}
+
+func (g *Guard) unauthorized() http.Handler {
+	if g.UnauthorizedHandler != nil {
+		return g.UnauthorizedHandler
+	}
+	return statusHandler(http.StatusForbidden)
+}
+
+func statusHandler(code int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(code), code)
+	})
+}
+
+// Returns the Subject for the request, along with a request which carries it in its context.
+// If the Subject was not already in the context, it is acquired with kuro.Get(), and the
+// returned function must be called to release it.
+func requestSubject(w http.ResponseWriter, r *http.Request) (kuro.Subject, *http.Request, func()) {
+	if subject := kuro.FromContext(r.Context()); subject != nil {
+		return subject, r, func() {}
+	}
+
+	subject := kuro.Get(r, w)
+
+	return subject, r.WithContext(kuro.NewContext(r.Context(), subject)), func() { kuro.Finish(r) }
+}
+
+// Wraps the handler so that it is only run if the check passes for the Subject.
+func guard(h http.Handler, check func(subject kuro.Subject) http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject, r, release := requestSubject(w, r)
+		defer release()
+
+		if failure := check(subject); failure != nil {
+			failure.ServeHTTP(w, r)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Only lets authenticated Subjects through.  Remembered Subjects are not enough.
+func RequiresAuthentication(h http.Handler) http.Handler {
+	return defaultGuard.RequiresAuthentication(h)
+}
+
+// Only lets known users through, that is, either authenticated or remembered Subjects.
+func RequiresUser(h http.Handler) http.Handler {
+	return defaultGuard.RequiresUser(h)
+}
+
+// Only lets anonymous Subjects through, e.g. for a sign-up page.
+func RequiresGuest(h http.Handler) http.Handler {
+	return defaultGuard.RequiresGuest(h)
+}
+
+// Returns a wrapper which lets only Subjects with the given roles through.
+func RequiresRoles(logical Logical, roles ...string) func(http.Handler) http.Handler {
+	return defaultGuard.RequiresRoles(logical, roles...)
+}
+
+// Returns a wrapper which lets only Subjects with the given permissions through.
+func RequiresPermissions(logical Logical, permissions ...string) func(http.Handler) http.Handler {
+	return defaultGuard.RequiresPermissions(logical, permissions...)
+}
+
+// Like the RequiresAuthentication() function, but with the handlers of the Guard.
+func (g *Guard) RequiresAuthentication(h http.Handler) http.Handler {
+	return guard(h, func(subject kuro.Subject) http.Handler {
+		if subject.IsAuthenticated() {
+			return nil
+		}
+		return g.unauthenticated()
+	})
+}
+
+// Like the RequiresUser() function, but with the handlers of the Guard.
+func (g *Guard) RequiresUser(h http.Handler) http.Handler {
+	return guard(h, func(subject kuro.Subject) http.Handler {
+		if subject.IsAuthenticated() || subject.IsRemembered() {
+			return nil
+		}
+		return g.unauthenticated()
+	})
+}
+
+// Like the RequiresGuest() function, but with the handlers of the Guard.
+func (g *Guard) RequiresGuest(h http.Handler) http.Handler {
+	return guard(h, func(subject kuro.Subject) http.Handler {
+		if subject.Principal() == nil {
+			return nil
+		}
+		return g.unauthorized()
+	})
+}
+
+// Like the RequiresRoles() function, but with the handlers of the Guard.
+func (g *Guard) RequiresRoles(logical Logical, roles ...string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return guard(h, func(subject kuro.Subject) http.Handler {
+			return g.check(subject, logical, roles, subject.HasRole)
+		})
+	}
+}
+
+// Like the RequiresPermissions() function, but with the handlers of the Guard.
+func (g *Guard) RequiresPermissions(logical Logical, permissions ...string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return guard(h, func(subject kuro.Subject) http.Handler {
+			return g.check(subject, logical, permissions, subject.IsPermitted)
+		})
+	}
+}
+
+func (g *Guard) check(subject kuro.Subject, logical Logical, vals []string, has func(string) bool) http.Handler {
+	if subject.Principal() == nil {
+		return g.unauthenticated()
+	}
+
+	for _, v := range vals {
+		ok := has(v)
+
+		if ok && logical == Or {
+			return nil
+		}
+
+		if !ok && logical == And {
+			return g.unauthorized()
+		}
+	}
+
+	if logical == Or && len(vals) > 0 {
+		return g.unauthorized()
+	}
+
+	return nil
+}
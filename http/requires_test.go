@@ -0,0 +1,53 @@
+package http
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestRequiresAuthentication(t *testing.T) {
+	h := RequiresAuthentication(ok)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(h, "/", "", "").Code)
+	assert.Equal(t, http.StatusOK, serve(h, "/", "foo", "password").Code)
+}
+
+func TestRequiresGuest(t *testing.T) {
+	h := RequiresGuest(ok)
+
+	assert.Equal(t, http.StatusOK, serve(h, "/", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(h, "/", "foo", "password").Code)
+}
+
+func TestRequiresRoles(t *testing.T) {
+	and := RequiresRoles(And, "manager", "admin")(ok)
+	or := RequiresRoles(Or, "manager", "admin")(ok)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(and, "/", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(and, "/", "foo", "password").Code)
+	assert.Equal(t, http.StatusOK, serve(or, "/", "foo", "password").Code)
+	assert.Equal(t, http.StatusOK, serve(or, "/", "bar", "password2").Code)
+}
+
+func TestRequiresPermissions(t *testing.T) {
+	and := RequiresPermissions(And, "printers:print", "printers:manage")(ok)
+	or := RequiresPermissions(Or, "printers:print", "printers:manage")(ok)
+
+	assert.Equal(t, http.StatusForbidden, serve(and, "/", "foo", "password").Code)
+	assert.Equal(t, http.StatusOK, serve(and, "/", "bar", "password2").Code)
+	assert.Equal(t, http.StatusOK, serve(or, "/", "foo", "password").Code)
+}
+
+func TestCustomHandlers(t *testing.T) {
+	g := &Guard{UnauthorizedHandler: http.RedirectHandler("/denied", http.StatusFound)}
+
+	w := serve(g.RequiresRoles(And, "admin")(ok), "/", "foo", "password")
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/denied", w.Header().Get("Location"))
+	assert.Equal(t, http.StatusUnauthorized, serve(g.RequiresRoles(And, "admin")(ok), "/", "", "").Code)
+
+	// Other wrappers keep the defaults
+	assert.Equal(t, http.StatusForbidden, serve(RequiresRoles(And, "admin")(ok), "/", "foo", "password").Code)
+}
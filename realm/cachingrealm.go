@@ -87,6 +87,32 @@ func (r *CachingRealm) AuthorizationInfo(principals []interface{}) (authz.Author
  */
 func (r *CachingRealm) ClearCache(principals []interface{}) {
 	for _,p := range principals {
-		r.cache.Del(p.(string))
+		// Only string principals are cached
+		if key, ok := p.(string); ok {
+			r.cache.Del(key)
+		}
+	}
+}
+
+/*
+	Passes the credentials to the backing realm, if it is an UpdatableCredentialsRealm, and
+	clears the cached AuthenticationInfo so that the new credentials are seen right away.
+ */
+func (r *CachingRealm) UpdateCredentials(info authc.AuthenticationInfo, credentials string) error {
+	ur, ok := r.realm.(UpdatableCredentialsRealm)
+
+	if !ok || !r.CanUpdateCredentials() {
+		return ErrNotUpdatable
 	}
+
+	err := ur.UpdateCredentials(info, credentials)
+
+	r.ClearCache(info.Principals())
+
+	return err
+}
+
+// Returns true, if the backing realm can update credentials.
+func (r *CachingRealm) CanUpdateCredentials() bool {
+	return CanUpdateCredentials(r.realm)
 }
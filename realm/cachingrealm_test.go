@@ -36,6 +36,37 @@ func TestCache(t *testing.T) {
 	assert.Equal(t, 2, mock.authinfocalled)
 }
 
+func TestCacheUpdateCredentials(t *testing.T) {
+	mock := MockRealm{}
+	cr := NewCaching( &mock, cache.NewMemoryCache() )
+
+	tok := authc.NewToken("foo", "bar")
+	info, _ := cr.AuthenticationInfo(tok)
+
+	assert.Equal(t, ErrNotUpdatable, cr.UpdateCredentials(info, "new"))
+	assert.Equal(t, 1, mock.authinfocalled)
+
+	um := updatableMockRealm{}
+	cr = NewCaching( &um, cache.NewMemoryCache() )
+
+	info, _ = cr.AuthenticationInfo(tok)
+	assert.NoError(t, cr.UpdateCredentials(info, "new"))
+	assert.Equal(t, "new", um.updated)
+
+	cr.AuthenticationInfo(tok)
+	assert.Equal(t, 2, um.authinfocalled, "The cache must be cleared")
+}
+
+type updatableMockRealm struct {
+	MockRealm
+	updated string
+}
+
+func (r *updatableMockRealm) UpdateCredentials(info authc.AuthenticationInfo, credentials string) error {
+	r.updated = credentials
+	return nil
+}
+
 // MockRealm
 
 type MockRealm struct {
@@ -81,3 +112,30 @@ func (r *MockRealm) IsPermitted(subjectPrincipal []interface{}, permission strin
 	return true
 }
 
+func (r *MockRealm) HasRoles(principals []interface{}, roles ...string) []bool {
+	res := make([]bool, len(roles))
+	for i := range res {
+		res[i] = true
+	}
+	return res
+}
+
+func (r *MockRealm) HasAllRoles(principals []interface{}, roles ...string) bool {
+	return true
+}
+
+func (r *MockRealm) IsPermittedEach(principals []interface{}, permissions ...string) []bool {
+	res := make([]bool, len(permissions))
+	for i := range res {
+		res[i] = true
+	}
+	return res
+}
+
+func (r *MockRealm) IsPermittedAll(principals []interface{}, permissions ...string) bool {
+	return true
+}
+
+func (r *MockRealm) IsPermittedAny(principals []interface{}, permissions ...string) bool {
+	return len(permissions) > 0
+}
@@ -9,11 +9,29 @@ import (
 	"github.com/jalkanen/kuro/authz"
 	"github.com/jalkanen/kuro/ini"
 	"io"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
 	ErrUnknownAccount error = errors.New("Unknown account")
+	ErrNotUpdatable   error = errors.New("The realm cannot update credentials")
+)
+
+const (
+	// Marks a parent role in the [roles] section of an IniRealm.
+	ParentRolePrefix = "@"
+
+	// The settings in the [main] section of an IniRealm.
+	CredentialsMatcherSetting = "credentialsMatcher"
+	HashAlgorithmSetting      = "hashAlgorithm"
+	HashIterationsSetting     = "hashIterations"
+
+	// The value of CredentialsMatcherSetting for plaintext passwords, and for any of the
+	// self-describing formats.  The rest are the formats of credential.NewPasswordEncoder().
+	PlainTextMatcher    = "plaintext"
+	ModularCryptMatcher = "modular"
 )
 
 // Realms are essentially user, role and permission databases.
@@ -36,6 +54,55 @@ type AuthorizingRealm interface {
 	AuthorizationInfo(principals []interface{}) (authz.AuthorizationInfo, error)
 }
 
+/*
+	An AccountRealm can tell cheaply whether it has an account for the principals.  For realms
+	which are also authz.Authorizers, the SecurityManager asks this instead of resolving the
+	AuthorizationInfo just to find out whether the realm knows the principals at all.
+*/
+type AccountRealm interface {
+	Realm
+	HasAccount(principals []interface{}) bool
+}
+
+// A ClockRealm checks time-bounded roles and permissions against a Clock which can be set.
+// The SecurityManager gives its own Clock to such realms; see kuro.DefaultSecurityManager.SetClock().
+type ClockRealm interface {
+	Realm
+	SetClock(clock authz.Clock)
+}
+
+/*
+	An UpdatableCredentialsRealm can store new credentials for an account.  If its
+	CredentialsMatcher is a credential.PasswordEncoder which finds the stored credentials
+	outdated, the SecurityManager encodes the password anew after a successful login and gives
+	the result to UpdateCredentials() to persist.  This way the hashes are upgraded gradually,
+	as the users log in.
+
+	An error does not fail the login; the same upgrade is simply tried again the next time.
+
+	A realm which merely passes the updates on, like CachingRealm, should also have a
+	CanUpdateCredentials() method telling whether they would be accepted, so that passwords
+	are not encoded for nothing; see CanUpdateCredentials().
+*/
+type UpdatableCredentialsRealm interface {
+	AuthenticatingRealm
+	UpdateCredentials(info authc.AuthenticationInfo, credentials string) error
+}
+
+// Returns true, if the realm is an UpdatableCredentialsRealm, and does not tell otherwise with
+// a CanUpdateCredentials() method.
+func CanUpdateCredentials(r Realm) bool {
+	if _, ok := r.(UpdatableCredentialsRealm); !ok {
+		return false
+	}
+
+	if probe, ok := r.(interface{ CanUpdateCredentials() bool }); ok {
+		return probe.CanUpdateCredentials()
+	}
+
+	return true
+}
+
 // A simple in-memory realm. Highly performant, but does not reload its contents, so
 // cannot be changed.  Useful for testing.  This is an AuthorizingRealm.
 type SimpleAccountRealm struct {
@@ -43,24 +110,136 @@ type SimpleAccountRealm struct {
 	users              map[string]authc.SimpleAccount
 	roles              map[string]authz.SimpleRole
 	credentialsMatcher credential.CredentialsMatcher
+	roleResolver       authz.RolePermissionResolver
+	permissionResolver authz.PermissionResolver
+	clock              authz.Clock
+
+	// Each role along with all the roles it inherits from
+	inherited map[string][]string
 }
 
-// Reads the contents from an .ini file; otherwise this is just a basic SimpleAccountRealm
+/*
+	Reads the contents from an .ini file; otherwise this is just a basic SimpleAccountRealm.
+
+	The [users] section lists "username = password, role1, role2...".  The [roles] section lists
+	"role = permission1, permission2...", where a permission starting with "!" is a denial, and
+	a name starting with "@" makes the role inherit from that role instead:
+
+		[roles]
+		editor = documents:*, !documents:*:delete
+		admin  = @editor, users:*
+
+	Here an admin is also an editor.  Inheritance may not loop.
+
+	Commas inside quotes do not separate the items.  A permission with several subparts is
+	given in double quotes, and so may be a password which contains commas:
+
+		[users]
+		carol = "pass,word", writer
+
+		[roles]
+		writer = "documents:read,write", users:read
+
+	Both roles in [users] and permissions in [roles] may be limited in time with "from" and
+	"until" (see authz.Validity), e.g. "bob = password, oncall until 2025-01-31".  Expired
+	grants are ignored; see SetClock().
+
+	A permission may also carry a condition on the attributes of the resource and the subject,
+	e.g. "author = documents:edit if resource.owner == subject.id"; see authz.ConditionalPermission.
+
+	By default the passwords are in plaintext.  The [main] section may pick another
+	CredentialsMatcher, whose hashes the [users] section then holds:
+
+		[main]
+		credentialsMatcher = pbkdf2
+		hashAlgorithm      = SHA-512
+		hashIterations     = 600000
+
+	The matchers are "plaintext", "modular" for a credential.ModularCrypt, which accepts any
+	self-describing hash, and the formats of credential.NewPasswordEncoder(): "shiro1",
+	"pbkdf2", "crypt" and "hashed".  For "hashed", a user line holds the salt and the hash as
+	"salt$hash" in base64, and the salt ends up in the CredentialsSalt() of the account.  The
+	kuro-hash command makes hashes for all of these.  WithCredentialsMatcher() overrides [main].
+*/
 type IniRealm struct {
 	SimpleAccountRealm
 }
 
+// An IniOption changes the way NewIni() reads the file.
+type IniOption func(*IniRealm)
+
+// Uses the given CredentialsMatcher, whatever the [main] section says.
+func WithCredentialsMatcher(matcher credential.CredentialsMatcher) IniOption {
+	return func(r *IniRealm) {
+		r.credentialsMatcher = matcher
+	}
+}
+
+// Resolves the permissions in the file, and later the strings given to IsPermitted() and
+// friends, with the given PermissionResolver.  Unlike SetPermissionResolver(), this also
+// covers the grants in the [roles] section, and the permissions added to the accounts.
+func WithPermissionResolver(resolver authz.PermissionResolver) IniOption {
+	return func(r *IniRealm) {
+		r.permissionResolver = resolver
+	}
+}
+
 func init() {
 	var s stringer
 	gob.Register(s)
 }
 
+// Returns the CredentialsMatcher the [main] section asks for.
+func credentialsMatcher(main map[string]string) (credential.CredentialsMatcher, error) {
+	iterations := 0
+
+	if s, ok := main[HashIterationsSetting]; ok {
+		var err error
+
+		if iterations, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("Invalid %s in the INI file: '%s'", HashIterationsSetting, s)
+		}
+	}
+
+	switch name := main[CredentialsMatcherSetting]; name {
+	case "", PlainTextMatcher:
+		return credential.NewPlain(), nil
+	case ModularCryptMatcher:
+		return credential.NewModularCrypt(), nil
+	default:
+		encoder, err := credential.NewPasswordEncoder(name, main[HashAlgorithmSetting], iterations)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s in the INI file: %s", CredentialsMatcherSetting, err)
+		}
+
+		return encoder, nil
+	}
+}
+
+// Creates the account of a user line.  For Hashed matchers, the salt and the hash are decoded.
+func (r *IniRealm) newAccount(username, credentials string) (*authc.SimpleAccount, error) {
+	if _, ok := r.credentialsMatcher.(*credential.Hashed); !ok {
+		return authc.NewAccount(stringer(username), credentials, r.name), nil
+	}
+
+	salt, hash, err := credential.DecodeSaltedHash(credentials)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid password hash for user %s: %s", username, err)
+	}
+
+	acct := authc.NewAccount(stringer(username), hash, r.name)
+	acct.SetCredentialsSalt(salt)
+
+	return acct, nil
+}
+
 // Creates a new IniRealm, reading from a Reader.
-func NewIni(name string, in io.Reader) (*IniRealm, error) {
+func NewIni(name string, in io.Reader, options ...IniOption) (*IniRealm, error) {
 	realm := IniRealm{SimpleAccountRealm{name: name}}
 	realm.users = make(map[string]authc.SimpleAccount)
 	realm.roles = make(map[string]authz.SimpleRole)
-	realm.credentialsMatcher = &credential.PlainText{}
 
 	ini, err := ini.Load(in)
 
@@ -68,19 +247,41 @@ func NewIni(name string, in io.Reader) (*IniRealm, error) {
 		return nil, err
 	}
 
+	realm.credentialsMatcher, err = credentialsMatcher(ini.Section("main"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, option := range options {
+		option(&realm)
+	}
+
 	// Users
 	for username, val := range ini.Section("users") {
-		vals := strings.Split(val, ",")
+		password, roles, err := splitPassword(val)
 
-		if len(vals) == 0 {
-			return nil, errors.New("Invalid property in the INI file; assumed at least a password for user " + username)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid property in the INI file for user %s: %s", username, err)
 		}
 
 		// User account
-		acct := authc.NewAccount(stringer(username), strings.TrimSpace(vals[0]), name)
+		acct, err := realm.newAccount(username, password)
+
+		if err != nil {
+			return nil, err
+		}
+
+		acct.PermissionResolver = realm.permissionResolver
+
+		for _, role := range roles {
+			role, validity, err := authz.ParseValidity(role)
+
+			if err != nil {
+				return nil, err
+			}
 
-		for _, role := range vals[1:] {
-			acct.AddRole(strings.TrimSpace(role))
+			acct.AddRole(role, validity)
 		}
 
 		realm.users[username] = *acct
@@ -88,16 +289,21 @@ func NewIni(name string, in io.Reader) (*IniRealm, error) {
 
 	// Roles
 	for role, permlist := range ini.Section("roles") {
-		perms := strings.Split(permlist, ",")
+		perms, err := splitList(permlist)
 
-		if len(perms) == 0 {
-			return nil, errors.New("Role does not have any permissions")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid permissions for role %s: %s", role, err)
 		}
 
 		r := authz.NewRole(role)
 
 		for _, p := range perms {
-			perm, err := authz.NewWildcardPermission(p)
+			if strings.HasPrefix(p, ParentRolePrefix) {
+				r.AddParent(strings.TrimSpace(p[len(ParentRolePrefix):]))
+				continue
+			}
+
+			perm, err := realm.resolvePermission(unquotePermission(p))
 
 			if err != nil {
 				return nil, err
@@ -108,9 +314,209 @@ func NewIni(name string, in io.Reader) (*IniRealm, error) {
 		realm.roles[role] = *r
 	}
 
+	if err := realm.compileRoles(); err != nil {
+		return nil, err
+	}
+
 	return &realm, nil
 }
 
+/*
+	Splits a value of the [roles] section on the commas which are not inside quotes, and trims
+	the items.  Quotes let a condition compare with a literal which contains a comma, e.g.
+	"documents:read if title == 'a, b'", and a permission have several subparts when it is
+	in double quotes, e.g. "printers:print,query".
+*/
+func splitList(s string) ([]string, error) {
+	var items []string
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("Unterminated quote")
+	}
+
+	return append(items, strings.TrimSpace(s[start:])), nil
+}
+
+// Removes the double quotes around a permission, which may follow a DenyPrefix and be followed
+// by a condition or a time window, e.g. !"printers:print,query" until 2025-12-31.
+func unquotePermission(p string) string {
+	prefix := ""
+
+	if strings.HasPrefix(p, authz.DenyPrefix) {
+		prefix, p = authz.DenyPrefix, strings.TrimSpace(p[len(authz.DenyPrefix):])
+	}
+
+	if strings.HasPrefix(p, `"`) {
+		if end := strings.Index(p[1:], `"`); end >= 0 {
+			p = p[1:end+1] + p[end+2:]
+		}
+	}
+
+	return prefix + p
+}
+
+// Splits a value of the [users] section into the password and the roles.  A password which
+// contains commas may be given in double quotes.
+func splitPassword(s string) (string, []string, error) {
+	s = strings.TrimSpace(s)
+	password, rest := s, ""
+
+	if strings.HasPrefix(s, `"`) {
+		end := strings.Index(s[1:], `"`)
+
+		if end < 0 {
+			return "", nil, errors.New("Unterminated quote in the password")
+		}
+
+		password, rest = s[1:end+1], strings.TrimSpace(s[end+2:])
+
+		if rest != "" && !strings.HasPrefix(rest, ",") {
+			return "", nil, errors.New("Expected a comma after the password")
+		}
+	} else if idx := strings.Index(s, ","); idx >= 0 {
+		password, rest = strings.TrimSpace(s[:idx]), s[idx:]
+	}
+
+	if rest == "" {
+		return password, nil, nil
+	}
+
+	roles, err := splitList(rest[1:])
+
+	return password, roles, err
+}
+
+// Works out the inherited roles of each role, and checks that the parents exist and the
+// inheritance does not loop.
+func (r *SimpleAccountRealm) compileRoles() error {
+	r.inherited = make(map[string][]string, len(r.roles))
+
+	for role, simplerole := range r.roles {
+		for _, parent := range simplerole.Parents() {
+			if _, ok := r.roles[parent]; !ok {
+				return fmt.Errorf("Role %s inherits from an undefined role %s", role, parent)
+			}
+		}
+	}
+
+	parents := func(role string) []string {
+		simplerole := r.roles[role]
+		return simplerole.Parents()
+	}
+
+	for role := range r.roles {
+		roles, err := authz.InheritedRoles(role, parents)
+
+		if err != nil {
+			return err
+		}
+
+		r.inherited[role] = roles
+	}
+
+	return nil
+}
+
+// Returns the given roles along with all the roles they inherit from.
+func (r *SimpleAccountRealm) InheritedRoles(roles ...string) []string {
+	res := make([]string, 0, len(roles))
+	seen := make(map[string]bool, len(roles))
+
+	for _, role := range roles {
+		inherited, ok := r.inherited[role]
+
+		if !ok {
+			inherited = []string{role}
+		}
+
+		for _, ir := range inherited {
+			if !seen[ir] {
+				seen[ir] = true
+				res = append(res, ir)
+			}
+		}
+	}
+
+	return res
+}
+
+// Sets the Clock against which time-bounded roles and permissions are checked.  The default
+// is authz.DefaultClock.
+func (r *SimpleAccountRealm) SetClock(clock authz.Clock) {
+	r.clock = clock
+}
+
+func (r *SimpleAccountRealm) now() time.Time {
+	if r.clock == nil {
+		return authz.DefaultClock.Now()
+	}
+
+	return r.clock.Now()
+}
+
+/*
+	Returns true, if the account has roles or permissions which are limited in time, either
+	directly or through its roles, so that the decisions about it may change at any moment.
+*/
+func (r *SimpleAccountRealm) TimeBounded(principals []interface{}) bool {
+	info, err := r.AuthorizationInfo(principals)
+
+	if err != nil {
+		return false
+	}
+
+	if authz.TimeBounded(info) {
+		return true
+	}
+
+	for _, role := range r.InheritedRoles(info.Roles()...) {
+		if simplerole, ok := r.roles[role]; ok && simplerole.PermissionSet().TimeBounded() {
+			return true
+		}
+
+		if r.roleResolver != nil && authz.NewPermissionSet(r.roleResolver.ResolvePermissionsInRole(role)...).TimeBounded() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true, if the account has the role, either directly or through inheritance.
+func (r *SimpleAccountRealm) hasRole(acct *authc.SimpleAccount, role string) bool {
+	now := r.now()
+
+	if acct.HasRoleAt(role, now) {
+		return true
+	}
+
+	for _, own := range acct.RolesAt(now) {
+		for _, ir := range r.inherited[own] {
+			if ir == role {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func (r *SimpleAccountRealm) Name() string {
 	return r.name
 }
@@ -155,6 +561,17 @@ func (r *SimpleAccountRealm) AuthorizationInfo(principals []interface{}) (authz.
 	return nil, ErrUnknownAccount
 }
 
+// Implements AccountRealm
+func (r *SimpleAccountRealm) HasAccount(principals []interface{}) bool {
+	if len(principals) == 0 {
+		return false
+	}
+
+	_, ok := r.users[fmt.Sprint(principals[0])]
+
+	return ok
+}
+
 // Authorizer interface
 
 func (r *SimpleAccountRealm) HasRole(principals []interface{}, role string) bool {
@@ -164,26 +581,89 @@ func (r *SimpleAccountRealm) HasRole(principals []interface{}, role string) bool
 
 	acct, ok := r.users[fmt.Sprint(principals[0])]
 
-	return ok && acct.HasRole(role)
+	return ok && r.hasRole(&acct, role)
 }
 
 func (r *SimpleAccountRealm) IsPermittedP(principals []interface{}, permission authz.Permission) bool {
 	acct, err := r.AuthorizationInfo(principals)
 
-	if err == nil {
-		for _, role := range acct.Roles() {
-			simplerole, gotit := r.roles[role]
+	return err == nil && r.permits(acct, permission)
+}
 
-			if gotit && simplerole.IsPermitted(permission) {
-				return true
-			}
+/*
+	Returns true, if the account itself or any of its roles, inherited ones included, grants the
+	permission, and none of them denies it.  A denial anywhere overrides all the grants; see
+	authz.DenyPermission.  Expired roles and permissions are ignored.
+*/
+func (r *SimpleAccountRealm) permits(acct authz.AuthorizationInfo, permission authz.Permission) bool {
+	return r.grantsOf(acct).Permits(permission)
+}
+
+// Returns what the permission checks for the principals are decided on.  This is meant for
+// explaining the decisions; see kuro.DefaultSecurityManager.Explain().
+func (r *SimpleAccountRealm) Grants(principals []interface{}) (*authz.Grants, error) {
+	acct, err := r.AuthorizationInfo(principals)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.grantsOf(acct), nil
+}
+
+func (r *SimpleAccountRealm) grantsOf(acct authz.AuthorizationInfo) *authz.Grants {
+	now := r.now()
+	roles := r.InheritedRoles(authz.RolesAt(acct, now)...)
+	sets := make([]*authz.PermissionSet, 0, len(roles)+2)
+
+	sets = append(sets, authz.PermissionSetOf(acct))
+
+	for _, role := range roles {
+		if simplerole, gotit := r.roles[role]; gotit {
+			sets = append(sets, simplerole.PermissionSet())
+		}
+
+		if r.roleResolver != nil {
+			sets = append(sets, authz.NewPermissionSet(r.roleResolver.ResolvePermissionsInRole(role)...))
 		}
 	}
-	return false
+
+	return &authz.Grants{Info: acct, Roles: roles, Sets: sets, Time: now}
+}
+
+// Sets a RolePermissionResolver which is consulted for the permissions of roles, in addition
+// to the roles defined in this realm.
+func (r *SimpleAccountRealm) SetRolePermissionResolver(resolver authz.RolePermissionResolver) {
+	r.roleResolver = resolver
+}
+
+// Sets the PermissionResolver which is used to parse the permission strings given to
+// IsPermitted() and friends.  The default is authz.DefaultPermissionResolver.  The grants
+// an IniRealm has already read are not affected; see WithPermissionResolver() for those.
+func (r *SimpleAccountRealm) SetPermissionResolver(resolver authz.PermissionResolver) {
+	r.permissionResolver = resolver
+}
+
+func (r *SimpleAccountRealm) resolvePermission(permission string) (authz.Permission, error) {
+	return authz.ResolvePermission(r.permissionResolver, permission)
+}
+
+// Implements authz.RolePermissionResolver, so that the roles defined in this realm can be
+// used for other realms, too.  The permissions of inherited roles are included.
+func (r *SimpleAccountRealm) ResolvePermissionsInRole(role string) []authz.Permission {
+	var perms []authz.Permission
+
+	for _, ir := range r.InheritedRoles(role) {
+		if simplerole, ok := r.roles[ir]; ok {
+			perms = append(perms, simplerole.Permissions()...)
+		}
+	}
+
+	return perms
 }
 
 func (r *SimpleAccountRealm) IsPermitted(subjectPrincipal []interface{}, permission string) bool {
-	p, err := authz.NewWildcardPermission(permission)
+	p, err := r.resolvePermission(permission)
 
 	if err != nil {
 		return false
@@ -192,6 +672,91 @@ func (r *SimpleAccountRealm) IsPermitted(subjectPrincipal []interface{}, permiss
 	return r.IsPermittedP(subjectPrincipal, p)
 }
 
+func (r *SimpleAccountRealm) HasRoles(principals []interface{}, roles ...string) []bool {
+	res := make([]bool, len(roles))
+
+	if len(principals) == 0 {
+		return res
+	}
+
+	if acct, ok := r.users[fmt.Sprint(principals[0])]; ok {
+		for i, role := range roles {
+			res[i] = r.hasRole(&acct, role)
+		}
+	}
+
+	return res
+}
+
+func (r *SimpleAccountRealm) HasAllRoles(principals []interface{}, roles ...string) bool {
+	for _, has := range r.HasRoles(principals, roles...) {
+		if !has {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *SimpleAccountRealm) IsPermittedEach(principals []interface{}, permissions ...string) []bool {
+	res := make([]bool, len(permissions))
+	acct, err := r.AuthorizationInfo(principals)
+
+	if err != nil {
+		return res
+	}
+
+	grants := r.grantsOf(acct)
+
+	for i, permission := range permissions {
+		if p, err := r.resolvePermission(permission); err == nil {
+			res[i] = grants.Permits(p)
+		}
+	}
+
+	return res
+}
+
+func (r *SimpleAccountRealm) IsPermittedAll(principals []interface{}, permissions ...string) bool {
+	acct, err := r.AuthorizationInfo(principals)
+
+	if err != nil {
+		return false
+	}
+
+	grants := r.grantsOf(acct)
+
+	for _, permission := range permissions {
+		p, err := r.resolvePermission(permission)
+
+		if err != nil || !grants.Permits(p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *SimpleAccountRealm) IsPermittedAny(principals []interface{}, permissions ...string) bool {
+	acct, err := r.AuthorizationInfo(principals)
+
+	if err != nil {
+		return false
+	}
+
+	grants := r.grantsOf(acct)
+
+	for _, permission := range permissions {
+		p, err := r.resolvePermission(permission)
+
+		if err == nil && grants.Permits(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Stringer interface
 
 func (r *IniRealm) String() string {
@@ -5,6 +5,9 @@ import (
 	"testing"
 	"strings"
 	"github.com/jalkanen/kuro/authc"
+	"github.com/jalkanen/kuro/authc/credential"
+	"github.com/jalkanen/kuro/authz"
+	"time"
 )
 
 func TestIni(t *testing.T) {
@@ -45,3 +48,429 @@ func TestIni(t *testing.T) {
 
 
 }
+
+func TestIniBulk(t *testing.T) {
+	src := `
+  [users]
+  foo = password, agroup, manager
+
+  [roles]
+  agroup = read:*
+  manager = write:*, manage:*
+`
+	ini, err := NewIni("test-ini", strings.NewReader(src))
+
+	assert.Nil(t, err)
+
+	foo := []interface{}{"foo"}
+	nobody := []interface{}{"nobody"}
+
+	assert.Equal(t, []bool{true, true, false}, ini.IsPermittedEach(foo, "read:x", "write:x", "delete:x"))
+	assert.Equal(t, []bool{false, false}, ini.IsPermittedEach(nobody, "read:x", "write:x"))
+	assert.True(t, ini.IsPermittedAll(foo, "read:x", "manage:y"))
+	assert.False(t, ini.IsPermittedAll(foo, "read:x", "delete:y"))
+	assert.True(t, ini.IsPermittedAny(foo, "delete:y", "read:x"))
+	assert.False(t, ini.IsPermittedAny(nobody, "read:x"))
+	assert.False(t, ini.IsPermittedAll(nobody), "Unknown accounts have no permissions at all")
+
+	assert.Equal(t, []bool{true, false}, ini.HasRoles(foo, "manager", "admin"))
+	assert.True(t, ini.HasAllRoles(foo, "manager", "agroup"))
+	assert.False(t, ini.HasAllRoles(nobody, "manager"))
+}
+
+func TestRolePermissionResolver(t *testing.T) {
+	src := `
+  [users]
+  foo = password, agroup, external
+`
+	ini, err := NewIni("test-ini", strings.NewReader(src))
+
+	assert.Nil(t, err)
+
+	foo := []interface{}{"foo"}
+
+	assert.False(t, ini.IsPermitted(foo, "printers:print"))
+
+	ini.SetRolePermissionResolver(authz.RolePermissionResolverFunc(func(role string) []authz.Permission {
+		if role == "external" {
+			p, _ := authz.NewWildcardPermission("printers:*")
+			return []authz.Permission{p}
+		}
+		return nil
+	}))
+
+	assert.True(t, ini.IsPermitted(foo, "printers:print"))
+	assert.False(t, ini.IsPermitted(foo, "scanners:scan"))
+	assert.Nil(t, ini.ResolvePermissionsInRole("external"))
+}
+
+func TestPermissionResolver(t *testing.T) {
+	src := `
+  [users]
+  foo = password, agroup
+
+  [roles]
+  agroup = read:*
+`
+	ini, err := NewIni("test-ini", strings.NewReader(src))
+
+	assert.Nil(t, err)
+
+	foo := []interface{}{"foo"}
+
+	ini.SetPermissionResolver(authz.PermissionResolverFunc(func(s string) (authz.Permission, error) {
+		return authz.NewWildcardPermission("read:" + s)
+	}))
+
+	assert.True(t, ini.IsPermitted(foo, "anything"))
+	assert.Equal(t, []bool{true}, ini.IsPermittedEach(foo, "else"))
+}
+
+// Only implies a permission with the same name
+type aclPermission string
+
+func (p aclPermission) Implies(other authz.Permission) bool {
+	o, ok := other.(aclPermission)
+	return ok && o == p
+}
+
+func (p aclPermission) String() string { return "acl:" + string(p) }
+
+func TestIniPermissionResolver(t *testing.T) {
+	src := `
+  [users]
+  foo = password, reader
+
+  [roles]
+  reader = acl:document/12:read, !acl:document/13:read, files:read
+`
+	resolver := authz.NewPrefixPermissionResolver()
+	resolver.Register("acl:", authz.PermissionResolverFunc(func(s string) (authz.Permission, error) {
+		return aclPermission(s), nil
+	}))
+
+	ini, err := NewIni("test-ini", strings.NewReader(src), WithPermissionResolver(resolver))
+
+	assert.Nil(t, err)
+
+	foo := []interface{}{"foo"}
+
+	assert.True(t, ini.IsPermitted(foo, "acl:document/12:read"))
+	assert.False(t, ini.IsPermitted(foo, "acl:document/13:read"))
+	assert.False(t, ini.IsPermitted(foo, "acl:document/12:write"))
+	assert.True(t, ini.IsPermitted(foo, "files:read"))
+
+	info, err := ini.AuthorizationInfo(foo)
+
+	assert.Nil(t, err)
+
+	acct := info.(*authc.SimpleAccount)
+	assert.Nil(t, acct.AddPermission("acl:document/14:read"))
+	assert.True(t, acct.IsPermitted("acl:document/14:read"))
+}
+
+func TestIniDeny(t *testing.T) {
+	src := `
+  [users]
+  foo = password, editor, nodelete
+  bar = password, editor
+
+  [roles]
+  editor = documents:*
+  nodelete = !documents:*:delete
+`
+	ini, err := NewIni("test-ini", strings.NewReader(src))
+
+	assert.Nil(t, err)
+
+	foo := []interface{}{"foo"}
+	bar := []interface{}{"bar"}
+
+	assert.Equal(t, []bool{true, false}, ini.IsPermittedEach(foo, "documents:12:read", "documents:12:delete"))
+	assert.Equal(t, []bool{true, true}, ini.IsPermittedEach(bar, "documents:12:read", "documents:12:delete"))
+	assert.False(t, ini.IsPermittedAll(foo, "documents:12:read", "documents:12:delete"))
+	assert.True(t, ini.IsPermittedAny(foo, "documents:12:read", "documents:12:delete"))
+}
+
+func TestAccountDeny(t *testing.T) {
+	src := `
+  [users]
+  foo = password, editor
+
+  [roles]
+  editor = documents:*
+`
+	ini, err := NewIni("test-ini", strings.NewReader(src))
+
+	assert.Nil(t, err)
+
+	acct := ini.users["foo"]
+	assert.Nil(t, acct.AddPermission("!documents:secret:*"))
+	assert.Nil(t, acct.AddPermission("reports:read"))
+	ini.users["foo"] = acct
+
+	foo := []interface{}{"foo"}
+
+	assert.True(t, ini.IsPermitted(foo, "documents:public:read"))
+	assert.False(t, ini.IsPermitted(foo, "documents:secret:read"))
+	assert.True(t, ini.IsPermitted(foo, "reports:read"))
+	assert.False(t, acct.IsPermitted("documents:secret:read"))
+}
+
+func TestIniRoleInheritance(t *testing.T) {
+	src := `
+  [users]
+  foo = password, admin
+  bar = password, editor
+  baz = password, auditor
+
+  [roles]
+  viewer = documents:*:read
+  editor = @viewer, documents:*:write
+  auditor = @viewer, logs:read
+  admin = @editor, @auditor, users:*
+`
+	ini, err := NewIni("test-ini", strings.NewReader(src))
+
+	assert.Nil(t, err)
+
+	foo := []interface{}{"foo"}
+	bar := []interface{}{"bar"}
+	baz := []interface{}{"baz"}
+
+	assert.True(t, ini.HasRole(foo, "editor"))
+	assert.Equal(t, []bool{true, true, true, true, false}, ini.HasRoles(foo, "admin", "editor", "auditor", "viewer", "other"))
+	assert.Equal(t, []bool{false, true, true}, ini.HasRoles(bar, "admin", "editor", "viewer"))
+	assert.False(t, ini.HasRole(baz, "editor"))
+
+	assert.Equal(t, []bool{true, true, true, true}, ini.IsPermittedEach(foo, "documents:1:read", "documents:1:write", "logs:read", "users:create"))
+	assert.Equal(t, []bool{true, true, false}, ini.IsPermittedEach(bar, "documents:1:read", "documents:1:write", "logs:read"))
+
+	assert.Equal(t, 4, len(ini.ResolvePermissionsInRole("admin")))
+}
+
+func TestIniRoleCycle(t *testing.T) {
+	src := `
+  [roles]
+  a = @b, read:*
+  b = @c
+  c = @a
+`
+	_, err := NewIni("test-ini", strings.NewReader(src))
+
+	if assert.Error(t, err) {
+		assert.IsType(t, &authz.RoleCycleError{}, err)
+	}
+
+	_, err = NewIni("test-ini", strings.NewReader("[roles]\nself = @self\n"))
+	assert.Error(t, err)
+}
+
+func TestIniUnknownParent(t *testing.T) {
+	src := `
+  [roles]
+  editor = @veiwer, documents:*:write
+  viewer = documents:*:read
+`
+	_, err := NewIni("test-ini", strings.NewReader(src))
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "veiwer")
+	}
+}
+
+func TestIniQuotedCommas(t *testing.T) {
+	src := `
+  [users]
+  foo = "pass,word", author, printer
+  bar = password, author
+
+  [roles]
+  author = documents:read, documents:edit if resource.title == 'a, b', wiki:read, mail:read if resource.note == 'sent from home'
+  printer = "printers:print,query:lp7200", !"printers:query:lp7200"
+`
+	ini, err := NewIni("test-ini", strings.NewReader(src))
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	foo := []interface{}{"foo"}
+	acct, _ := ini.AuthenticationInfo(authc.NewToken("foo", "pass,word"))
+
+	assert.Equal(t, "pass,word", acct.Credentials())
+	assert.Equal(t, []bool{true, true}, ini.HasRoles(foo, "author", "printer"))
+	assert.Equal(t, []bool{true, true, true, false}, ini.IsPermittedEach(foo, "documents:read", "wiki:read", "printers:print:lp7200", "printers:query:lp7200"))
+	assert.Len(t, ini.ResolvePermissionsInRole("author"), 4)
+
+	edit, _ := authz.NewWildcardPermission("documents:edit")
+	resource := map[string]interface{}{"title": "a, b"}
+
+	assert.True(t, ini.IsPermittedP(foo, authz.NewResourcePermission(edit, resource, nil)))
+
+	for _, bad := range []string{
+		"[roles]\nauthor = documents:edit if title == 'a, b\n",
+		"[users]\nfoo = \"password, admin\n",
+		"[users]\nfoo = \"pass\"word, admin\n",
+		"[roles]\nauthor = !!documents:edit\n",
+	} {
+		_, err := NewIni("test-ini", strings.NewReader(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestIniConditional(t *testing.T) {
+	src := `
+  [users]
+  foo = password, author
+
+  [roles]
+  author = documents:read, documents:edit if resource.owner == subject.id
+`
+	ini, err := NewIni("test-ini", strings.NewReader(src))
+
+	assert.Nil(t, err)
+
+	foo := []interface{}{"foo"}
+	edit, _ := authz.NewWildcardPermission("documents:edit")
+
+	own := authz.NewResourcePermission(edit, map[string]interface{}{"owner": "foo"}, map[string]interface{}{"id": "foo"})
+	other := authz.NewResourcePermission(edit, map[string]interface{}{"owner": "bar"}, map[string]interface{}{"id": "foo"})
+
+	assert.True(t, ini.IsPermittedP(foo, own))
+	assert.False(t, ini.IsPermittedP(foo, other))
+	assert.False(t, ini.IsPermitted(foo, "documents:edit"))
+	assert.True(t, ini.IsPermitted(foo, "documents:read"))
+}
+
+func TestIniTimeBounded(t *testing.T) {
+	src := `
+  [users]
+  foo = password, contractor until 2025-12-31, staff
+  bar = password, oncall from 2025-12-01
+
+  [roles]
+  contractor = documents:read
+  staff = wiki:read, wiki:edit until 2025-06-30
+  oncall = servers:restart
+`
+	ini, err := NewIni("test-ini", strings.NewReader(src))
+
+	assert.Nil(t, err)
+
+	foo := []interface{}{"foo"}
+	bar := []interface{}{"bar"}
+	now := time.Date(2025, 12, 31, 23, 0, 0, 0, time.UTC)
+
+	ini.SetClock(authz.ClockFunc(func() time.Time { return now }))
+
+	assert.True(t, ini.HasRole(foo, "contractor"))
+	assert.True(t, ini.IsPermitted(foo, "documents:read"))
+	assert.Equal(t, []bool{true, false}, ini.IsPermittedEach(foo, "wiki:read", "wiki:edit"))
+	assert.True(t, ini.HasRole(bar, "oncall"))
+	assert.True(t, ini.IsPermitted(bar, "servers:restart"))
+
+	now = now.Add(time.Hour)
+
+	assert.False(t, ini.HasRole(foo, "contractor"))
+	assert.True(t, ini.HasRole(foo, "staff"))
+	assert.False(t, ini.IsPermitted(foo, "documents:read"))
+	assert.True(t, ini.IsPermitted(foo, "wiki:read"))
+
+	now = time.Date(2025, 11, 30, 0, 0, 0, 0, time.UTC)
+
+	assert.False(t, ini.HasRole(bar, "oncall"))
+	assert.False(t, ini.IsPermitted(bar, "servers:restart"))
+	assert.False(t, ini.IsPermitted(foo, "wiki:edit"))
+
+	now = time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	assert.True(t, ini.IsPermitted(foo, "wiki:edit"), "Valid until the end of the day")
+
+	_, err = NewIni("test-ini", strings.NewReader("[users]\nfoo = password, contractor until someday\n"))
+	assert.Error(t, err)
+}
+
+func TestIniCredentialsMatcher(t *testing.T) {
+	pbkdf, _ := credential.NewPBKDF2("SHA-512", 10).Encode([]byte("foopw"))
+	crypt, _ := credential.NewCrypt("SHA-256", 0).Encode([]byte("barpw"))
+	hashed, _ := credential.NewHashed("SHA-256", 2).Encode([]byte("bazpw"))
+
+	login := func(r *IniRealm, user, password string) bool {
+		token := authc.NewToken(user, password)
+		info, err := r.AuthenticationInfo(token)
+
+		return err == nil && r.CredentialsMatcher().Match(token, info)
+	}
+
+	ini, err := NewIni("test-ini", strings.NewReader(`
+  [main]
+  credentialsMatcher = pbkdf2
+  hashAlgorithm = SHA-512
+  hashIterations = 10
+
+  [users]
+  foo = `+pbkdf+`, admin
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, credential.NewPBKDF2("SHA-512", 10), ini.CredentialsMatcher())
+	assert.True(t, login(ini, "foo", "foopw"))
+	assert.False(t, login(ini, "foo", pbkdf))
+	assert.True(t, ini.HasRole([]interface{}{"foo"}, "admin"))
+
+	ini, err = NewIni("test-ini", strings.NewReader(`
+  [main]
+  credentialsMatcher = modular
+
+  [users]
+  foo = `+pbkdf+`
+  bar = `+crypt+`
+`))
+	assert.Nil(t, err)
+	assert.True(t, login(ini, "foo", "foopw"))
+	assert.True(t, login(ini, "bar", "barpw"))
+	assert.False(t, login(ini, "bar", "foopw"))
+
+	ini, err = NewIni("test-ini", strings.NewReader(`
+  [main]
+  credentialsMatcher = hashed
+  hashIterations = 2
+
+  [users]
+  baz = `+hashed+`
+`))
+	assert.Nil(t, err)
+	assert.True(t, login(ini, "baz", "bazpw"))
+	assert.False(t, login(ini, "baz", "foopw"))
+
+	info, _ := ini.AuthenticationInfo(authc.NewToken("baz", "bazpw"))
+	salt, _, _ := credential.DecodeSaltedHash(hashed)
+	assert.Equal(t, salt, info.(*authc.SimpleAccount).CredentialsSalt())
+	assert.Len(t, salt, credential.DefaultSaltLength)
+
+	// The option wins over [main]
+	ini, err = NewIni("test-ini", strings.NewReader(`
+  [main]
+  credentialsMatcher = shiro1
+
+  [users]
+  foo = `+crypt+`
+`), WithCredentialsMatcher(credential.NewCrypt("SHA-256", 0)))
+	assert.Nil(t, err)
+	assert.True(t, login(ini, "foo", "barpw"))
+
+	// Plaintext is still the default
+	ini, err = NewIni("test-ini", strings.NewReader("[users]\nfoo = password\n"))
+	assert.Nil(t, err)
+	assert.True(t, login(ini, "foo", "password"))
+
+	for _, src := range []string{
+		"[main]\ncredentialsMatcher = bcrypt\n",
+		"[main]\ncredentialsMatcher = pbkdf2\nhashAlgorithm = MD5\n",
+		"[main]\ncredentialsMatcher = shiro1\nhashIterations = many\n",
+		"[main]\ncredentialsMatcher = hashed\n[users]\nfoo = not base64!\n",
+	} {
+		_, err = NewIni("test-ini", strings.NewReader(src))
+		assert.Error(t, err, src)
+	}
+}
@@ -0,0 +1,219 @@
+package kuro
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"github.com/jalkanen/kuro/authc"
+	"io"
+	"net/http"
+	"time"
+)
+
+/*
+	A RememberMeManager remembers the identity of a Subject across sessions, so that a user
+	who has asked to be remembered does not need to log in again for every visit.
+
+	Note that a remembered Subject is not authenticated; it has principals, but IsAuthenticated()
+	returns false and IsRemembered() returns true.  Sensitive operations should still require
+	a proper login.
+*/
+type RememberMeManager interface {
+	// Returns the remembered principals for the Subject being created, or nil if there are none.
+	RememberedPrincipals(ctx *SubjectContext) []interface{}
+
+	// Called after a successful login.  The identity should be remembered only if the token asks for it.
+	OnSuccessfulLogin(subject Subject, token authc.AuthenticationToken, info authc.AuthenticationInfo)
+
+	// Called after a failed login.  Any previously remembered identity should be forgotten.
+	OnFailedLogin(subject Subject, token authc.AuthenticationToken, err error)
+
+	// Called when the Subject logs out.  Any previously remembered identity should be forgotten.
+	OnLogout(subject Subject)
+}
+
+/*
+	CookieRememberMeManager stores the principals in a cookie.  The principals are serialized
+	with encoding/gob, so any custom principal types must be registered with gob.Register().
+	The cookie is encrypted and authenticated with AES-GCM, so it can be neither read nor
+	forged without the key.
+*/
+type CookieRememberMeManager struct {
+	// The name of the cookie.  Defaults to "rememberMe".
+	CookieName string
+
+	// How long the identity is remembered.  Defaults to one year.
+	MaxAge time.Duration
+
+	// Cookie attributes.  Path defaults to "/" and HttpOnly to true.
+	Path     string
+	Domain   string
+	Secure   bool
+	HttpOnly bool
+
+	aead cipher.AEAD
+}
+
+const (
+	DefaultRememberMeCookie = "rememberMe"
+)
+
+// Creates a new CookieRememberMeManager.  The key must be 16, 24 or 32 bytes long, selecting
+// AES-128, AES-192 or AES-256.  Keep it secret, and keep it the same across restarts and
+// across all servers, or remembered identities will be lost.
+func NewCookieRememberMe(key []byte) (*CookieRememberMeManager, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &CookieRememberMeManager{
+		CookieName: DefaultRememberMeCookie,
+		MaxAge:     365 * 24 * time.Hour,
+		Path:       "/",
+		HttpOnly:   true,
+		aead:       aead,
+	}, nil
+}
+
+func (m *CookieRememberMeManager) RememberedPrincipals(ctx *SubjectContext) []interface{} {
+	if ctx.Request == nil {
+		return nil
+	}
+
+	c, err := ctx.Request.Cookie(m.CookieName)
+
+	if err != nil {
+		return nil
+	}
+
+	principals, err := m.decrypt(c.Value)
+
+	if err != nil {
+		// Most likely a stale or tampered cookie, so get rid of it
+		if ctx.ResponseWriter != nil {
+			m.forget(ctx.ResponseWriter)
+		}
+		return nil
+	}
+
+	return principals
+}
+
+func (m *CookieRememberMeManager) OnSuccessfulLogin(subject Subject, token authc.AuthenticationToken, info authc.AuthenticationInfo) {
+	w := responseOf(subject)
+
+	if w == nil {
+		return
+	}
+
+	if rt, ok := token.(authc.RememberMeAuthenticationToken); ok && rt.IsRememberMe() {
+		value, err := m.encrypt(info.Principals())
+
+		if err == nil {
+			m.setCookie(w, value, int(m.MaxAge.Seconds()))
+			return
+		}
+	}
+
+	m.forget(w)
+}
+
+func (m *CookieRememberMeManager) OnFailedLogin(subject Subject, token authc.AuthenticationToken, err error) {
+	if w := responseOf(subject); w != nil {
+		m.forget(w)
+	}
+}
+
+func (m *CookieRememberMeManager) OnLogout(subject Subject) {
+	if w := responseOf(subject); w != nil {
+		m.forget(w)
+	}
+}
+
+func (m *CookieRememberMeManager) forget(w http.ResponseWriter) {
+	m.setCookie(w, "", -1)
+}
+
+func (m *CookieRememberMeManager) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.CookieName,
+		Value:    value,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.Secure,
+		HttpOnly: m.HttpOnly,
+	})
+}
+
+// The cookie name is used as additional data, so that a value cannot be moved to another cookie.
+func (m *CookieRememberMeManager) encrypt(principals []interface{}) (string, error) {
+	var b bytes.Buffer
+
+	if err := gob.NewEncoder(&b).Encode(principals); err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, m.aead.NonceSize())
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := m.aead.Seal(nonce, nonce, b.Bytes(), []byte(m.CookieName))
+
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (m *CookieRememberMeManager) decrypt(value string) ([]interface{}, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ns := m.aead.NonceSize()
+
+	if len(sealed) < ns {
+		return nil, errors.New("RememberMe cookie is too short")
+	}
+
+	plain, err := m.aead.Open(nil, sealed[:ns], sealed[ns:], []byte(m.CookieName))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var principals []interface{}
+
+	if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&principals); err != nil {
+		return nil, err
+	}
+
+	if len(principals) == 0 {
+		return nil, errors.New("RememberMe cookie has no principals")
+	}
+
+	return principals, nil
+}
+
+// Returns the ResponseWriter for the Subject, if it is tied to an HTTP request.
+func responseOf(subject Subject) http.ResponseWriter {
+	if ha, ok := subject.(httpAware); ok {
+		return ha.Response()
+	}
+
+	return nil
+}
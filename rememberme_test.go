@@ -0,0 +1,134 @@
+package kuro
+
+import (
+	"fmt"
+	"github.com/jalkanen/kuro/authc"
+	"github.com/jalkanen/kuro/realm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newRememberMeManager(t *testing.T) *DefaultSecurityManager {
+	msm := newSecurityManager()
+	r, _ := realm.NewIni("ini", strings.NewReader(ini))
+	msm.SetRealm(r)
+
+	rm, err := NewCookieRememberMe([]byte("0123456789abcdef"))
+	require.NoError(t, err)
+	msm.RememberMeManager = rm
+
+	return msm
+}
+
+// Creates a Subject for a request which carries the given cookies.
+func requestSubject(msm *DefaultSecurityManager, w http.ResponseWriter, cookies ...*http.Cookie) Subject {
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+
+	subject, _ := msm.CreateSubject(&SubjectContext{
+		Request:        req,
+		ResponseWriter: w,
+	})
+
+	return subject
+}
+
+func rememberMeCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == DefaultRememberMeCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestRememberMe(t *testing.T) {
+	msm := newRememberMeManager(t)
+
+	w := httptest.NewRecorder()
+	subject := requestSubject(msm, w)
+
+	require.NoError(t, subject.Login(authc.NewTokenRemember("foo", "password", true)))
+
+	cookie := rememberMeCookie(w)
+	require.NotNil(t, cookie)
+	assert.True(t, cookie.HttpOnly)
+	assert.NotContains(t, cookie.Value, "foo")
+
+	// The next request should get a remembered, but not authenticated Subject
+	w = httptest.NewRecorder()
+	subject = requestSubject(msm, w, cookie)
+
+	assert.True(t, subject.IsRemembered())
+	assert.False(t, subject.IsAuthenticated())
+	assert.Equal(t, "foo", fmt.Sprint(subject.Principal()))
+	assert.True(t, subject.HasRole("manager"))
+
+	// Logging out forgets the identity
+	subject.Logout()
+
+	forgotten := rememberMeCookie(w)
+	require.NotNil(t, forgotten)
+	assert.Equal(t, "", forgotten.Value)
+	assert.True(t, forgotten.MaxAge < 0)
+}
+
+func TestRememberMeNotRequested(t *testing.T) {
+	msm := newRememberMeManager(t)
+
+	w := httptest.NewRecorder()
+	subject := requestSubject(msm, w)
+
+	require.NoError(t, subject.Login(authc.NewToken("foo", "password")))
+
+	cookie := rememberMeCookie(w)
+	require.NotNil(t, cookie)
+	assert.Equal(t, "", cookie.Value)
+}
+
+func TestRememberMeFailedLogin(t *testing.T) {
+	msm := newRememberMeManager(t)
+
+	w := httptest.NewRecorder()
+	subject := requestSubject(msm, w)
+	require.NoError(t, subject.Login(authc.NewTokenRemember("foo", "password", true)))
+	cookie := rememberMeCookie(w)
+
+	w = httptest.NewRecorder()
+	subject = requestSubject(msm, w, cookie)
+
+	assert.Error(t, subject.Login(authc.NewTokenRemember("foo", "wrong", true)))
+	assert.Equal(t, "", rememberMeCookie(w).Value)
+}
+
+func TestRememberMeTampered(t *testing.T) {
+	msm := newRememberMeManager(t)
+
+	w := httptest.NewRecorder()
+	subject := requestSubject(msm, w)
+	require.NoError(t, subject.Login(authc.NewTokenRemember("foo", "password", true)))
+	cookie := rememberMeCookie(w)
+
+	cookie.Value = "A" + cookie.Value[1:]
+	if cookie.Value == rememberMeCookie(w).Value {
+		cookie.Value = "B" + cookie.Value[1:]
+	}
+
+	w = httptest.NewRecorder()
+	subject = requestSubject(msm, w, cookie)
+
+	assert.False(t, subject.IsRemembered())
+	assert.Nil(t, subject.Principal())
+}
+
+func TestRememberMeKey(t *testing.T) {
+	_, err := NewCookieRememberMe([]byte("too short"))
+	assert.Error(t, err)
+}
@@ -4,11 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jalkanen/kuro/authc"
+	"github.com/jalkanen/kuro/authc/credential"
 	"github.com/jalkanen/kuro/authz"
-	"github.com/jalkanen/kuro/http"
 	"github.com/jalkanen/kuro/realm"
 	"github.com/jalkanen/kuro/session"
 	"log"
+	"net/http"
 	"sync/atomic"
 	"time"
 )
@@ -29,6 +30,28 @@ type SecurityManager interface {
 	Login(Subject, authc.AuthenticationToken) error
 	Logout(Subject) error
 	SessionManager() session.SessionManager
+
+	// The Check methods return nil if the check passes, an *authz.UnauthenticatedError if
+	// there are no principals, or an *authz.UnauthorizedError naming the first missing
+	// role or permission.
+	CheckRole(principals []interface{}, role string) error
+	CheckRoles(principals []interface{}, roles ...string) error
+	CheckPermission(principals []interface{}, permission string) error
+	CheckPermissions(principals []interface{}, permissions ...string) error
+
+	// Checks a permission on a particular resource, whose attributes conditional permissions
+	// may look at.  See authz.ConditionalPermission.
+	IsPermittedOn(principals []interface{}, permission string, resource map[string]interface{}) bool
+
+	// Tells why the permission is or is not granted.  See Explanation.
+	Explain(principals []interface{}, permission string) *Explanation
+}
+
+// httpAware has the same method set as http.HTTPAware.  It is repeated here so that the
+// http package can itself depend on kuro without an import cycle.
+type httpAware interface {
+	Request() *http.Request
+	Response() http.ResponseWriter
 }
 
 var (
@@ -55,6 +78,26 @@ type DefaultSecurityManager struct {
 	realms                 []realm.Realm
 	sessionManager         session.SessionManager
 	AuthenticationStrategy AuthenticationStrategy
+
+	// If set, remembers the identity of Subjects which log in with a RememberMe token.
+	RememberMeManager RememberMeManager
+
+	// If true, every Subject created by this SecurityManager remembers its authorization
+	// decisions, as if SubjectContext.MemoizeDecisions was set.  This is meant for Subjects
+	// which live for a single request: changes in the realms are not seen until the Subject
+	// logs in, logs out or changes its RunAs identity.  The decisions about principals with
+	// time-bounded roles or permissions are not remembered, as they may change at any moment.
+	MemoizeDecisions bool
+
+	// Set with SetAuthorizationPolicy(), SetRolePermissionResolver(), SetPermissionResolver()
+	// and SetClock()
+	authorizationPolicy    AuthorizationPolicy
+	rolePermissionResolver authz.RolePermissionResolver
+	permissionResolver     authz.PermissionResolver
+	clock                  authz.Clock
+
+	// The *ModularRealmAuthorizer for the current realms and settings, or nil if there is none yet
+	modular atomic.Value
 }
 
 // Replaces the realms with a single realm
@@ -64,6 +107,8 @@ func (sm *DefaultSecurityManager) SetRealm(r realm.Realm) {
 	// Clear away old realms
 	sm.realms = make([]realm.Realm, 1)
 	sm.realms[0] = r
+	sm.modular.Store((*ModularRealmAuthorizer)(nil))
+	sm.giveClock(r)
 }
 
 // Add a new Realm.  Note that during authentication, Realms are checked in the
@@ -71,6 +116,50 @@ func (sm *DefaultSecurityManager) SetRealm(r realm.Realm) {
 func (sm *DefaultSecurityManager) AddRealm(r realm.Realm) {
 	sm.logf("Adding new realm %s", r.Name())
 	sm.realms = append(sm.realms, r)
+	sm.modular.Store((*ModularRealmAuthorizer)(nil))
+	sm.giveClock(r)
+}
+
+/*
+	Sets the Clock against which time-bounded roles and permissions are checked.  The
+	AuthorizationInfos of AuthorizingRealms are checked against it, and it is given to every
+	realm.ClockRealm, including those added later, so that both agree on the time.  Like the
+	realms, set this before use.  If none is set, authz.DefaultClock is used.
+*/
+func (sm *DefaultSecurityManager) SetClock(clock authz.Clock) {
+	sm.clock = clock
+	sm.modular.Store((*ModularRealmAuthorizer)(nil))
+
+	for _, r := range sm.realms {
+		sm.giveClock(r)
+	}
+}
+
+// Sets how the answers of multiple realms are combined in authorization checks.  If nil,
+// AnyGrantPolicy is used.
+func (sm *DefaultSecurityManager) SetAuthorizationPolicy(policy AuthorizationPolicy) {
+	sm.authorizationPolicy = policy
+	sm.modular.Store((*ModularRealmAuthorizer)(nil))
+}
+
+// If set, the role names returned by AuthorizingRealms are resolved into permissions with
+// this during permission checks.  Realms which are authz.Authorizers resolve their own roles.
+func (sm *DefaultSecurityManager) SetRolePermissionResolver(resolver authz.RolePermissionResolver) {
+	sm.rolePermissionResolver = resolver
+	sm.modular.Store((*ModularRealmAuthorizer)(nil))
+}
+
+// Sets what turns permission strings into Permissions for AuthorizingRealms.  Realms which are
+// authz.Authorizers parse the strings themselves.  If nil, authz.DefaultPermissionResolver is used.
+func (sm *DefaultSecurityManager) SetPermissionResolver(resolver authz.PermissionResolver) {
+	sm.permissionResolver = resolver
+	sm.modular.Store((*ModularRealmAuthorizer)(nil))
+}
+
+func (sm *DefaultSecurityManager) giveClock(r realm.Realm) {
+	if cr, ok := r.(realm.ClockRealm); ok && sm.clock != nil {
+		cr.SetClock(sm.clock)
+	}
 }
 
 func (sm *DefaultSecurityManager) SessionManager() session.SessionManager {
@@ -99,6 +188,10 @@ func (sm *DefaultSecurityManager) Authenticate(token authc.AuthenticationToken)
 		return nil, err
 	}
 
+	// The realms whose credentials matched, and may need a rehash
+	var matched []realm.AuthenticatingRealm
+	var matchedInfos []authc.AuthenticationInfo
+
 	for _, r := range sm.realms {
 
 		aggregate, err = sm.AuthenticationStrategy.BeforeAttempt(r, token, aggregate)
@@ -118,6 +211,9 @@ func (sm *DefaultSecurityManager) Authenticate(token authc.AuthenticationToken)
 				if match := ar.CredentialsMatcher().Match(token, ai); !match {
 					sm.logf("While an account was found, the given credentials did not match for realm %s", r.Name())
 					err = errors.New("Incorrect credentials given")
+				} else {
+					matched = append(matched, ar)
+					matchedInfos = append(matchedInfos, ai)
 				}
 			}
 
@@ -139,9 +235,48 @@ func (sm *DefaultSecurityManager) Authenticate(token authc.AuthenticationToken)
 		return nil, errors.New("Unknown user account")
 	}
 
+	for i, r := range matched {
+		if ur, ok := r.(realm.UpdatableCredentialsRealm); ok && realm.CanUpdateCredentials(ur) {
+			sm.rehash(ur, token, matchedInfos[i])
+		}
+	}
+
 	return aggregate, nil
 }
 
+// If the stored credentials are outdated, gives the realm the password encoded anew.
+func (sm *DefaultSecurityManager) rehash(r realm.UpdatableCredentialsRealm, token authc.AuthenticationToken, info authc.AuthenticationInfo) {
+	encoder, ok := r.CredentialsMatcher().(credential.PasswordEncoder)
+
+	if !ok || !encoder.NeedsRehash(info.Credentials()) {
+		return
+	}
+
+	var password []byte
+
+	switch c := token.Credentials().(type) {
+	case []byte:
+		password = c
+	case string:
+		password = []byte(c)
+	default:
+		return
+	}
+
+	encoded, err := encoder.Encode(password)
+
+	if err == nil {
+		err = r.UpdateCredentials(info, encoded)
+	}
+
+	if err != nil {
+		sm.logf("Could not update the credentials of %s in realm %s: %s", token.Principal(), r.Name(), err.Error())
+		return
+	}
+
+	sm.logf("Updated the credentials of %s in realm %s", token.Principal(), r.Name())
+}
+
 // Since bools aren't atomic, we use just a simple int32 with the atomic package
 var configMissingWarning int32
 
@@ -155,6 +290,17 @@ func (sm *DefaultSecurityManager) CreateSubject(ctx *SubjectContext) (Subject, e
 
 	sub := newSubject(sm, *ctx)
 
+	if sm.MemoizeDecisions {
+		sub.decisions = newDecisions()
+	}
+
+	if len(sub.principals) == 0 && sm.RememberMeManager != nil {
+		if p := sm.RememberMeManager.RememberedPrincipals(ctx); p != nil {
+			sub.principals = p
+			sub.authenticated = false
+		}
+	}
+
 	sm.logf("Created new Subject: %v", sub)
 
 	if sm.SessionManager() != nil && ctx.CreateSessions {
@@ -166,55 +312,61 @@ func (sm *DefaultSecurityManager) CreateSubject(ctx *SubjectContext) (Subject, e
 	return sub, nil
 }
 
-func (sm *DefaultSecurityManager) HasRole(principals []interface{}, role string) bool {
+// Returns the Authorizer which combines the answers of all the realms using the
+// AuthorizationPolicy.  It is built only once, and again after the realms or the settings are set.
+func (sm *DefaultSecurityManager) authorizer() *ModularRealmAuthorizer {
+	if a, _ := sm.modular.Load().(*ModularRealmAuthorizer); a != nil {
+		return a
+	}
 
-	for _, re := range sm.realms {
-		r, ok := re.(authz.Authorizer)
+	a := NewModularRealmAuthorizer(sm.realms, sm.authorizationPolicy)
+	a.RolePermissionResolver = sm.rolePermissionResolver
+	a.PermissionResolver = sm.permissionResolver
+	a.Clock = sm.clock
 
-		if ok && r.HasRole(principals, role) {
-			return true
-		}
+	sm.modular.Store(a)
 
-		if rr, ok := re.(realm.AuthorizingRealm); ok {
-			info, _ := rr.AuthorizationInfo(principals)
+	return a
+}
 
-			if info != nil {
-				return containsString(info.Roles(), role)
+/*
+	Returns true, if any realm which knows the principals gives them roles or permissions which
+	are limited in time.  Realms which are authz.Authorizers are asked with a TimeBounded()
+	method, as realm.SimpleAccountRealm has; those without one are assumed to have none.
+*/
+func (sm *DefaultSecurityManager) timeBounded(principals []interface{}) bool {
+	for _, r := range sm.realms {
+		if tb, ok := r.(interface{ TimeBounded(principals []interface{}) bool }); ok {
+			if tb.TimeBounded(principals) {
+				return true
 			}
+			continue
 		}
-	}
 
-	return false
-}
+		if _, ok := r.(authz.Authorizer); ok {
+			continue
+		}
 
-// Returns true, if the slice contains the given value.
-func containsString(slice []string, val string) bool {
-	for _, k := range slice {
-		if k == val {
-			return true
+		ar, ok := r.(realm.AuthorizingRealm)
+
+		if !ok {
+			continue
 		}
-	}
-	return false
-}
 
-func (sm *DefaultSecurityManager) IsPermittedP(principals []interface{}, permission authz.Permission) bool {
-	if len(principals) == 0 {
-		return false
-	}
+		info, _ := ar.AuthorizationInfo(principals)
 
-	for _, re := range sm.realms {
-		if r, ok := re.(authz.Authorizer); ok {
-			return r.IsPermittedP(principals, permission)
+		if info == nil {
+			continue
 		}
 
-		if r, ok := re.(realm.AuthorizingRealm); ok {
-			info, _ := r.AuthorizationInfo(principals)
+		if authz.TimeBounded(info) {
+			return true
+		}
 
-			if info != nil {
-				for _, p := range info.Permissions() {
-					if p.Implies(permission) {
-						return true
-					}
+		if sm.rolePermissionResolver != nil {
+			for _, role := range info.Roles() {
+				if authz.NewPermissionSet(sm.rolePermissionResolver.ResolvePermissionsInRole(role)...).TimeBounded() {
+					return true
 				}
 			}
 		}
@@ -223,31 +375,95 @@ func (sm *DefaultSecurityManager) IsPermittedP(principals []interface{}, permiss
 	return false
 }
 
+func (sm *DefaultSecurityManager) HasRole(principals []interface{}, role string) bool {
+	return sm.authorizer().HasRole(principals, role)
+}
+
+func (sm *DefaultSecurityManager) IsPermittedP(principals []interface{}, permission authz.Permission) bool {
+	return sm.authorizer().IsPermittedP(principals, permission)
+}
+
 func (sm *DefaultSecurityManager) IsPermitted(principals []interface{}, permission string) bool {
+	return sm.authorizer().IsPermitted(principals, permission)
+}
+
+// Checks all the roles at once, asking each realm only once.
+func (sm *DefaultSecurityManager) HasRoles(principals []interface{}, roles ...string) []bool {
+	return sm.authorizer().HasRoles(principals, roles...)
+}
+
+func (sm *DefaultSecurityManager) HasAllRoles(principals []interface{}, roles ...string) bool {
+	return sm.authorizer().HasAllRoles(principals, roles...)
+}
+
+// Checks all the permissions at once, asking each realm only once.
+func (sm *DefaultSecurityManager) IsPermittedEach(principals []interface{}, permissions ...string) []bool {
+	return sm.authorizer().IsPermittedEach(principals, permissions...)
+}
+
+func (sm *DefaultSecurityManager) IsPermittedAll(principals []interface{}, permissions ...string) bool {
+	return sm.authorizer().IsPermittedAll(principals, permissions...)
+}
+
+func (sm *DefaultSecurityManager) IsPermittedAny(principals []interface{}, permissions ...string) bool {
+	return sm.authorizer().IsPermittedAny(principals, permissions...)
+}
+
+/*
+	Checks the permission against the resource with the given attributes.  The subject attributes
+	start with "id", which is the primary principal as a string; the realms add the attributes of
+	the account, which replace those of the same name.
+*/
+func (sm *DefaultSecurityManager) IsPermittedOn(principals []interface{}, permission string, resource map[string]interface{}) bool {
 	if len(principals) == 0 {
 		return false
 	}
 
-	for _, re := range sm.realms {
-		if r, ok := re.(authz.Authorizer); ok {
-			return r.IsPermitted(principals, permission)
+	p, err := authz.ResolvePermission(sm.permissionResolver, permission)
+
+	if err != nil {
+		return false
+	}
+
+	subject := map[string]interface{}{"id": fmt.Sprint(principals[0])}
+
+	return sm.IsPermittedP(principals, authz.NewResourcePermission(p, resource, subject))
+}
+
+func (sm *DefaultSecurityManager) CheckRole(principals []interface{}, role string) error {
+	return sm.CheckRoles(principals, role)
+}
+
+func (sm *DefaultSecurityManager) CheckRoles(principals []interface{}, roles ...string) error {
+	if len(principals) == 0 {
+		return &authz.UnauthenticatedError{}
+	}
+
+	for i, has := range sm.HasRoles(principals, roles...) {
+		if !has {
+			return &authz.UnauthorizedError{Role: roles[i]}
 		}
+	}
 
-		if r, ok := re.(realm.AuthorizingRealm); ok {
-			info, _ := r.AuthorizationInfo(principals)
+	return nil
+}
 
-			if info != nil {
-				compiledperm, _ := authz.NewWildcardPermission(permission)
-				for _, p := range info.Permissions() {
-					if p.Implies(compiledperm) {
-						return true
-					}
-				}
-			}
+func (sm *DefaultSecurityManager) CheckPermission(principals []interface{}, permission string) error {
+	return sm.CheckPermissions(principals, permission)
+}
+
+func (sm *DefaultSecurityManager) CheckPermissions(principals []interface{}, permissions ...string) error {
+	if len(principals) == 0 {
+		return &authz.UnauthenticatedError{}
+	}
+
+	for i, ok := range sm.IsPermittedEach(principals, permissions...) {
+		if !ok {
+			return &authz.UnauthorizedError{Permission: permissions[i]}
 		}
 	}
 
-	return false
+	return nil
 }
 
 func (sm *DefaultSecurityManager) Login(subject Subject, token authc.AuthenticationToken) error {
@@ -268,12 +484,21 @@ func (sm *DefaultSecurityManager) Login(subject Subject, token authc.Authenticat
 		sm.logf("Login successful, got principal list: %v", subject)
 
 		if sm.sessionManager != nil {
+			sm.renewSession(d)
 			d.store()
 		}
 
+		if sm.RememberMeManager != nil {
+			sm.RememberMeManager.OnSuccessfulLogin(subject, token, ai)
+		}
+
 		return nil
 	}
 
+	if sm.RememberMeManager != nil {
+		sm.RememberMeManager.OnFailedLogin(subject, token, err)
+	}
+
 	return err
 }
 
@@ -286,17 +511,61 @@ func (sm *DefaultSecurityManager) Logout(subject Subject) error {
 
 	sm.logf("Logging out user '%s' (for Subject %v)", d.principals, d)
 
+	if sm.RememberMeManager != nil {
+		sm.RememberMeManager.OnLogout(subject)
+	}
+
 	// Mark user logged out and clear the principals
 	d.authenticated = false
 	d.principals = make([]interface{}, 0, 16)
 
 	if sm.sessionManager != nil && d.session != nil {
-		if ha, ok := d.session.(http.HTTPAware); ok {
-			sm.sessionManager.Invalidate(session.NewWebKey(d.session.Id(), ha.Request(), ha.Response()))
-		} else {
-			sm.sessionManager.Invalidate(session.NewKey(d.session.Id()))
-		}
+		sm.invalidateSession(d)
 	}
 
 	return nil
 }
+
+func (sm *DefaultSecurityManager) invalidateSession(d *Delegator) {
+	if ha, ok := d.session.(httpAware); ok {
+		sm.sessionManager.Invalidate(session.NewWebKey(d.session.Id(), ha.Request(), ha.Response()))
+	} else if d.request != nil {
+		sm.sessionManager.Invalidate(session.NewWebKey(d.session.Id(), d.request, d.response))
+	} else {
+		sm.sessionManager.Invalidate(session.NewKey(d.session.Id()))
+	}
+}
+
+/*
+	Replaces the Session of the Subject with a new one, so that a session id which somebody
+	knew before the login is of no use after it.  The attributes are copied over, if the old
+	Session can list them with a Keys() method, as session.DefaultSession can.
+*/
+func (sm *DefaultSecurityManager) renewSession(d *Delegator) {
+	old := d.Session()
+
+	if old == nil {
+		return
+	}
+
+	attributes := make(map[interface{}]interface{})
+
+	if k, ok := old.(interface{ Keys() []interface{} }); ok {
+		for _, key := range k.Keys() {
+			attributes[key] = old.Get(key)
+		}
+	}
+
+	sm.invalidateSession(d)
+
+	d.session = sm.sessionManager.Start(&session.SessionContext{
+		Request:  d.request,
+		Response: d.response,
+	})
+
+	for key, value := range attributes {
+		d.session.Set(key, value)
+	}
+
+	sm.logf("Renewed session %s as %s", old.Id(), d.session.Id())
+}
@@ -43,6 +43,16 @@ func (g *Session) Set(key interface{}, val interface{}) {
 	g.dirty = true
 }
 
+func (g *Session) Keys() []interface{} {
+	keys := make([]interface{}, 0, len(g.gsession.Values))
+
+	for k := range g.gsession.Values {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
 func (g *Session) Save() {
 
 	if g.dirty {
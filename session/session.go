@@ -60,6 +60,7 @@ type DefaultSession struct {
 func NewDefault(timeout time.Duration) *DefaultSession {
 	return &DefaultSession{
 		sessionid:  randomKey(),
+		valid:      true,
 		expires:    time.Now().Add(timeout),
 		attributes: make(map[interface{}]interface{}, 8),
 	}
@@ -94,6 +95,20 @@ func (s *DefaultSession) Del(key interface{}) {
 	delete(s.attributes,key)
 }
 
+// Returns the keys of all the attributes.
+func (s *DefaultSession) Keys() []interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys := make([]interface{}, 0, len(s.attributes))
+
+	for k := range s.attributes {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
 func (s *DefaultSession) Save() {
 	// DefaultSession does not store its data anywhere
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -36,10 +37,30 @@ type SessionManager interface {
 	Invalidate(key Key)
 }
 
+const (
+	DefaultSessionCookie = "SESSIONID"
+)
+
+// CookieOptions control the cookie which is used to track the session id.
+type CookieOptions struct {
+	Name     string
+	Path     string
+	Domain   string
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
 /*
-	MemorySessionManager just keeps the Sessions in memo
+	MemorySessionManager just keeps the Sessions in memory.  For web requests, the session id
+	is tracked with a cookie, configurable through the Cookie field.  Note that since the sessions
+	are not shared, this only works when there is a single server.
 */
 type MemorySessionManager struct {
+	// Settings for the session cookie.  The defaults are a HttpOnly cookie called
+	// DefaultSessionCookie, with path "/" and SameSite=Lax.
+	Cookie CookieOptions
+
 	lock     sync.Mutex
 	sessions map[string]*DefaultSession
 	expiry   time.Duration
@@ -64,6 +85,12 @@ func logf(format string, vars ...interface{}) {
 // destroys them when needed
 func NewMemory(expiry time.Duration) *MemorySessionManager {
 	sm := &MemorySessionManager{
+		Cookie: CookieOptions{
+			Name:     DefaultSessionCookie,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
 		sessions: make(map[string]*DefaultSession, 64),
 		expiry:   expiry,
 		quit:     make(chan struct{}),
@@ -74,10 +101,21 @@ func NewMemory(expiry time.Duration) *MemorySessionManager {
 	return sm
 }
 
+// Starts a new session.  If the request carries the cookie of a valid session, that session
+// is returned instead, and a new one is only created when there is none.
 func (sm *MemorySessionManager) Start(ctx *SessionContext) Session {
 	sm.lock.Lock()
 	defer sm.lock.Unlock()
 
+	if ctx.Request != nil {
+		if c, err := ctx.Request.Cookie(sm.Cookie.Name); err == nil {
+			if s := sm.sessions[c.Value]; s != nil && s.IsValid() {
+				logf("Resumed session: %s", s)
+				return s
+			}
+		}
+	}
+
 	expiry := ctx.Expiry
 
 	if expiry == 0 {
@@ -88,11 +126,45 @@ func (sm *MemorySessionManager) Start(ctx *SessionContext) Session {
 
 	sm.sessions[s.sessionid] = s
 
+	if ctx.Response != nil {
+		sm.setCookie(ctx.Response, s.sessionid, 0)
+	}
+
 	logf("Started new session: %s", s)
 
 	return s
 }
 
+// Sets the session cookie, replacing any which was set earlier in the same response, e.g. when
+// a session is invalidated and a new one started.
+func (sm *MemorySessionManager) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	header := w.Header()
+	cookies := header["Set-Cookie"][:0]
+
+	for _, c := range header["Set-Cookie"] {
+		if !strings.HasPrefix(c, sm.Cookie.Name+"=") {
+			cookies = append(cookies, c)
+		}
+	}
+
+	if len(cookies) > 0 {
+		header["Set-Cookie"] = cookies
+	} else {
+		header.Del("Set-Cookie")
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sm.Cookie.Name,
+		Value:    value,
+		Path:     sm.Cookie.Path,
+		Domain:   sm.Cookie.Domain,
+		MaxAge:   maxAge,
+		Secure:   sm.Cookie.Secure,
+		HttpOnly: sm.Cookie.HttpOnly,
+		SameSite: sm.Cookie.SameSite,
+	})
+}
+
 func (sm *MemorySessionManager) Get(key Key) Session {
 	sm.lock.Lock()
 	defer sm.lock.Unlock()
@@ -113,6 +185,10 @@ func (sm *MemorySessionManager) Invalidate(key Key) {
 	logf("Invalidated session: %s", key)
 
 	delete(sm.sessions, key.Id())
+
+	if k, ok := key.(WebKey); ok && k.Response != nil {
+		sm.setCookie(k.Response, "", -1)
+	}
 }
 
 func (sm *MemorySessionManager) reap() {
@@ -0,0 +1,73 @@
+package session
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func sessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == DefaultSessionCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestMemoryCookie(t *testing.T) {
+	sm := NewMemory(time.Minute)
+	sm.Cookie.Secure = true
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	w := httptest.NewRecorder()
+
+	s := sm.Start(&SessionContext{Request: req, Response: w})
+	s.Set("foo", "bar")
+
+	assert.True(t, s.IsValid())
+
+	cookie := sessionCookie(w)
+	require.NotNil(t, cookie)
+	assert.Equal(t, s.Id(), cookie.Value)
+	assert.Equal(t, "/", cookie.Path)
+	assert.True(t, cookie.Secure)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	// The next request resumes the same session
+	req, _ = http.NewRequest("GET", "http://example.com/", nil)
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+
+	s2 := sm.Start(&SessionContext{Request: req, Response: w})
+
+	assert.Equal(t, s.Id(), s2.Id())
+	assert.Equal(t, "bar", s2.Get("foo"))
+	assert.Nil(t, sessionCookie(w))
+
+	// Invalidation clears the cookie, and the next request gets a new session
+	w = httptest.NewRecorder()
+	sm.Invalidate(NewWebKey(s2.Id(), req, w))
+
+	assert.False(t, s2.IsValid())
+	assert.Equal(t, "", sessionCookie(w).Value)
+
+	w = httptest.NewRecorder()
+	s3 := sm.Start(&SessionContext{Request: req, Response: w})
+
+	assert.NotEqual(t, s.Id(), s3.Id())
+	assert.Equal(t, s3.Id(), sessionCookie(w).Value)
+}
+
+func TestMemoryNoRequest(t *testing.T) {
+	sm := NewMemory(time.Minute)
+
+	s := sm.Start(&SessionContext{})
+
+	assert.True(t, s.IsValid())
+	assert.Equal(t, s, sm.Get(NewKey(s.Id())))
+}
@@ -1,6 +1,7 @@
 package kuro
 
 import (
+	"context"
 	"encoding/gob"
 	"errors"
 	"fmt"
@@ -33,6 +34,17 @@ type Subject interface {
 	IsAuthenticated() bool
 	IsPermitted(permission string) bool
 	IsPermittedP(permission authz.Permission) bool
+	HasRoles(roles ...string) []bool
+	HasAllRoles(roles ...string) bool
+	IsPermittedEach(permissions ...string) []bool
+	IsPermittedAll(permissions ...string) bool
+	IsPermittedAny(permissions ...string) bool
+	IsPermittedOn(permission string, resource map[string]interface{}) bool
+	Explain(permission string) *Explanation
+	CheckRole(role string) error
+	CheckRoles(roles ...string) error
+	CheckPermission(permission string) error
+	CheckPermissions(permissions ...string) error
 	Login(authc.AuthenticationToken) error
 	Logout()
 	RunAs([]interface{}) error
@@ -54,6 +66,7 @@ type Delegator struct {
 	createSessions bool
 	request        *http.Request
 	response       http.ResponseWriter
+	decisions      *decisions
 }
 
 const (
@@ -72,9 +85,76 @@ func newSubject(securityManager SecurityManager, ctx SubjectContext) *Delegator
 		response:       ctx.ResponseWriter,
 	}
 
+	if ctx.MemoizeDecisions {
+		d.decisions = newDecisions()
+	}
+
 	return &d
 }
 
+const (
+	roleDecision = iota
+	permissionDecision
+)
+
+// Remembers the answers to role and permission checks of a single Subject.  A nil
+// *decisions remembers nothing.
+type decisions struct {
+	mutex   sync.Mutex
+	answers [2]map[string]bool
+
+	// Whether it has been checked if the answers may change with time, and whether they may
+	checked     bool
+	timeBounded bool
+}
+
+// Implemented by DefaultSecurityManager
+type timeBoundedChecker interface {
+	timeBounded(principals []interface{}) bool
+}
+
+func newDecisions() *decisions {
+	d := &decisions{}
+	d.forget()
+
+	return d
+}
+
+func (d *decisions) lookup(kind int, key string) (answer bool, found bool) {
+	if d == nil {
+		return false, false
+	}
+
+	d.mutex.Lock()
+	answer, found = d.answers[kind][key]
+	d.mutex.Unlock()
+
+	return answer, found
+}
+
+func (d *decisions) store(kind int, key string, answer bool) {
+	if d == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	d.answers[kind][key] = answer
+	d.mutex.Unlock()
+}
+
+func (d *decisions) forget() {
+	if d == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	for i := range d.answers {
+		d.answers[i] = make(map[string]bool)
+	}
+	d.checked = false
+	d.mutex.Unlock()
+}
+
 var lock sync.Mutex
 var subjects map[interface{}]Subject = make(map[interface{}]Subject, 64)
 
@@ -83,24 +163,21 @@ var subjects map[interface{}]Subject = make(map[interface{}]Subject, 64)
 // with a corresponding call to Finish()
 // The Subject itself can be shared among goroutines.
 // This only works with the global SecurityManager
+//
+// If the request context already carries a Subject (see NewContext and Middleware), that
+// Subject is returned instead, and calling Finish() is not necessary.
 func Get(r *http.Request, w http.ResponseWriter) Subject {
+	if subject := FromContext(r.Context()); subject != nil {
+		return subject
+	}
+
 	lock.Lock()
 	defer lock.Unlock()
 
 	subject := subjects[r]
 
 	if subject == nil {
-
-		sc := SubjectContext{
-			CreateSessions: true,
-			Request:        r,
-			ResponseWriter: w,
-		}
-		subject, _ = Manager.CreateSubject(&sc)
-
-		if d, ok := subject.(*Delegator); ok {
-			d.load()
-		}
+		subject = newRequestSubject(r, w)
 
 		// Store this one for the request to avoid further calls to the session
 		subjects[r] = subject
@@ -109,6 +186,23 @@ func Get(r *http.Request, w http.ResponseWriter) Subject {
 	return subject
 }
 
+// Creates a Subject for the request from the global SecurityManager, restoring its state from
+// the Session.
+func newRequestSubject(r *http.Request, w http.ResponseWriter) Subject {
+	sc := SubjectContext{
+		CreateSessions: true,
+		Request:        r,
+		ResponseWriter: w,
+	}
+	subject, _ := Manager.CreateSubject(&sc)
+
+	if d, ok := subject.(*Delegator); ok {
+		d.load()
+	}
+
+	return subject
+}
+
 func With(where *http.Request, s Subject) {
 	lock.Lock()
 	defer lock.Unlock()
@@ -123,6 +217,38 @@ func Finish(where *http.Request) {
 	delete(subjects, where)
 }
 
+type contextKey int
+
+const subjectKey contextKey = 0
+
+// Returns a copy of ctx which carries the given Subject.
+func NewContext(ctx context.Context, subject Subject) context.Context {
+	return context.WithValue(ctx, subjectKey, subject)
+}
+
+// Returns the Subject stored in the context with NewContext(), or nil if there is none.
+func FromContext(ctx context.Context) Subject {
+	subject, _ := ctx.Value(subjectKey).(Subject)
+	return subject
+}
+
+// Middleware acquires the Subject for each request from the global SecurityManager and binds it
+// to the request context before calling next.  Handlers further down can then get the Subject
+// with FromContext(r.Context()), or with Get(), which checks the context first.  Since nothing
+// is stored globally, there is no need to call Finish().
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject := FromContext(r.Context())
+
+		if subject == nil {
+			subject = newRequestSubject(r, w)
+			r = r.WithContext(NewContext(r.Context(), subject))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // TODO: Should return something else in error?
 func (s *Delegator) Principal() interface{} {
 	p := s.Principals()
@@ -163,8 +289,45 @@ func (s *Delegator) Session() session.Session {
 	return s.session
 }
 
+/*
+	Returns the decisions to remember, or nil if none are.  The answers for principals with
+	time-bounded roles or permissions are not remembered, since they may change at any moment.
+	This is found out once, the first time it is needed.
+*/
+func (s *Delegator) memo() *decisions {
+	d := s.decisions
+
+	if d == nil {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.checked {
+		tc, ok := s.mgr.(timeBoundedChecker)
+		d.timeBounded = ok && s.hasPrincipals() && tc.timeBounded(s.Principals())
+		d.checked = true
+	}
+
+	if d.timeBounded {
+		return nil
+	}
+
+	return d
+}
+
 func (s *Delegator) HasRole(role string) bool {
-	return s.hasPrincipals() && s.mgr.HasRole(s.Principals(), role)
+	memo := s.memo()
+
+	if answer, found := memo.lookup(roleDecision, role); found {
+		return answer
+	}
+
+	answer := s.hasPrincipals() && s.mgr.HasRole(s.Principals(), role)
+	memo.store(roleDecision, role, answer)
+
+	return answer
 }
 
 func (s *Delegator) IsAuthenticated() bool {
@@ -177,25 +340,120 @@ func (s *Delegator) IsRemembered() bool {
 
 // Swallows the error in case for simplicity
 func (s *Delegator) IsPermitted(permission string) bool {
-	return s.hasPrincipals() && s.mgr.IsPermitted(s.Principals(), permission)
+	memo := s.memo()
+
+	if answer, found := memo.lookup(permissionDecision, permission); found {
+		return answer
+	}
+
+	answer := s.hasPrincipals() && s.mgr.IsPermitted(s.Principals(), permission)
+	memo.store(permissionDecision, permission, answer)
+
+	return answer
 }
 
 func (s *Delegator) IsPermittedP(permission authz.Permission) bool {
 	return s.hasPrincipals() && s.mgr.IsPermittedP(s.Principals(), permission)
 }
 
+// Returns one result for each role, in the same order.
+func (s *Delegator) HasRoles(roles ...string) []bool {
+	if !s.hasPrincipals() {
+		return make([]bool, len(roles))
+	}
+
+	return s.mgr.HasRoles(s.Principals(), roles...)
+}
+
+func (s *Delegator) HasAllRoles(roles ...string) bool {
+	return s.hasPrincipals() && s.mgr.HasAllRoles(s.Principals(), roles...)
+}
+
+// Returns one result for each permission, in the same order.  This is much faster than calling
+// IsPermitted() separately for each, e.g. when deciding which parts of a page to show.
+func (s *Delegator) IsPermittedEach(permissions ...string) []bool {
+	if !s.hasPrincipals() {
+		return make([]bool, len(permissions))
+	}
+
+	return s.mgr.IsPermittedEach(s.Principals(), permissions...)
+}
+
+func (s *Delegator) IsPermittedAll(permissions ...string) bool {
+	return s.hasPrincipals() && s.mgr.IsPermittedAll(s.Principals(), permissions...)
+}
+
+func (s *Delegator) IsPermittedAny(permissions ...string) bool {
+	return s.hasPrincipals() && s.mgr.IsPermittedAny(s.Principals(), permissions...)
+}
+
+// Checks the permission on a resource with the given attributes, so that conditional
+// permissions such as "documents:edit if resource.owner == subject.id" can be decided.
+func (s *Delegator) IsPermittedOn(permission string, resource map[string]interface{}) bool {
+	return s.hasPrincipals() && s.mgr.IsPermittedOn(s.Principals(), permission, resource)
+}
+
+// Tells why the Subject does or does not have the permission.  Unlike IsPermitted(), this is
+// never memoized.
+func (s *Delegator) Explain(permission string) *Explanation {
+	return s.mgr.Explain(s.Principals(), permission)
+}
+
+// Returns nil, if the Subject has the role.  Otherwise returns an *authz.UnauthenticatedError
+// if the Subject is not logged in, or an *authz.UnauthorizedError if it lacks the role.
+func (s *Delegator) CheckRole(role string) error {
+	return s.CheckRoles(role)
+}
+
+// Like CheckRole(), but all of the roles are required.
+func (s *Delegator) CheckRoles(roles ...string) error {
+	if !s.hasPrincipals() {
+		return &authz.UnauthenticatedError{}
+	}
+
+	return s.mgr.CheckRoles(s.Principals(), roles...)
+}
+
+// Returns nil, if the Subject is permitted.  Otherwise returns an *authz.UnauthenticatedError
+// if the Subject is not logged in, or an *authz.UnauthorizedError if it lacks the permission.
+func (s *Delegator) CheckPermission(permission string) error {
+	return s.CheckPermissions(permission)
+}
+
+// Like CheckPermission(), but all of the permissions are required.
+func (s *Delegator) CheckPermissions(permissions ...string) error {
+	if !s.hasPrincipals() {
+		return &authz.UnauthenticatedError{}
+	}
+
+	return s.mgr.CheckPermissions(s.Principals(), permissions...)
+}
+
 func (s *Delegator) hasPrincipals() bool {
 	return s.principals != nil && len(s.principals) > 0
 }
 
 func (s *Delegator) Login(token authc.AuthenticationToken) error {
 	s.clearPrincipalStack()
+	defer s.decisions.forget()
+
 	return s.mgr.Login(s, token)
 }
 
 func (s *Delegator) Logout() {
 	s.clearPrincipalStack()
 	s.mgr.Logout(s)
+	s.decisions.forget()
+}
+
+// Returns the HTTP request this Subject was created for, if any.
+func (s *Delegator) Request() *http.Request {
+	return s.request
+}
+
+// Returns the HTTP response this Subject was created for, if any.
+func (s *Delegator) Response() http.ResponseWriter {
+	return s.response
 }
 
 // Stringer. Outputs a nicer version of the subject's principals and whether it is authenticated or not.
@@ -258,6 +516,7 @@ func (s *Delegator) RunAs(newprincipals []interface{}) error {
 
 	s.storePrincipalStack(ps)
 	s.Session().Save()
+	s.decisions.forget()
 
 	return nil
 }
@@ -282,6 +541,7 @@ func (s *Delegator) ReleaseRunAs() ([]interface{}, error) {
 	}
 
 	s.Session().Save()
+	s.decisions.forget()
 
 	return principals, nil
 }
@@ -2,10 +2,14 @@ package kuro
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"github.com/gorilla/sessions"
 	"github.com/jalkanen/kuro/authc"
+	"github.com/jalkanen/kuro/authc/credential"
+	"github.com/jalkanen/kuro/authz"
+	"github.com/jalkanen/kuro/cache"
 	"github.com/jalkanen/kuro/realm"
 	"github.com/jalkanen/kuro/session"
 	"github.com/jalkanen/kuro/session/gorilla"
@@ -124,6 +128,407 @@ func TestCreate(t *testing.T) {
 
 }
 
+func TestCheck(t *testing.T) {
+	subject, _ := sm.CreateSubject(&SubjectContext{
+		CreateSessions: true,
+	})
+
+	assert.IsType(t, &authz.UnauthenticatedError{}, subject.CheckRole("manager"))
+	assert.IsType(t, &authz.UnauthenticatedError{}, subject.CheckPermission("write:foo"))
+
+	require.NoError(t, subject.Login(authc.NewToken("foo", "password")))
+
+	assert.NoError(t, subject.CheckRole("manager"))
+	assert.NoError(t, subject.CheckRoles())
+	assert.NoError(t, subject.CheckPermissions("write:foo", "manage:bar"))
+
+	err := subject.CheckRoles("manager", "admin")
+	require.IsType(t, &authz.UnauthorizedError{}, err)
+	assert.Equal(t, "admin", err.(*authz.UnauthorizedError).Role)
+
+	err = subject.CheckPermissions("write:foo", "read:foo")
+	require.IsType(t, &authz.UnauthorizedError{}, err)
+	assert.Equal(t, "read:foo", err.(*authz.UnauthorizedError).Permission)
+	assert.Contains(t, err.Error(), "read:foo")
+
+	subject.Logout()
+}
+
+func TestBulk(t *testing.T) {
+	subject, _ := sm.CreateSubject(&SubjectContext{
+		CreateSessions: true,
+	})
+
+	assert.Equal(t, []bool{false, false}, subject.IsPermittedEach("write:foo", "read:foo"))
+	assert.False(t, subject.IsPermittedAll())
+
+	require.NoError(t, subject.Login(authc.NewToken("foo", "password")))
+
+	assert.Equal(t, []bool{true, false, true}, subject.IsPermittedEach("write:foo", "read:foo", "manage:bar"))
+	assert.True(t, subject.IsPermittedAll("write:foo", "manage:bar"))
+	assert.False(t, subject.IsPermittedAll("write:foo", "read:foo"))
+	assert.True(t, subject.IsPermittedAll())
+	assert.True(t, subject.IsPermittedAny("read:foo", "write:foo"))
+	assert.False(t, subject.IsPermittedAny("read:foo"))
+	assert.False(t, subject.IsPermittedAny())
+
+	assert.Equal(t, []bool{true, false}, subject.HasRoles("manager", "admin"))
+	assert.True(t, subject.HasAllRoles("manager"))
+	assert.False(t, subject.HasAllRoles("manager", "admin"))
+
+	subject.Logout()
+}
+
+// An AuthorizingRealm which is not an Authorizer, and counts how often it is asked.
+type countingRealm struct {
+	calls int
+}
+
+func (r *countingRealm) Name() string                                  { return "counting" }
+func (r *countingRealm) Supports(token authc.AuthenticationToken) bool { return false }
+func (r *countingRealm) CredentialsMatcher() credential.CredentialsMatcher {
+	return credential.NewPlain()
+}
+func (r *countingRealm) AuthenticationInfo(token authc.AuthenticationToken) (authc.AuthenticationInfo, error) {
+	return nil, realm.ErrUnknownAccount
+}
+func (r *countingRealm) AuthorizationInfo(principals []interface{}) (authz.AuthorizationInfo, error) {
+	r.calls++
+	info := &authz.SimpleAuthorizationInfo{}
+	info.AddRole("printer")
+	info.AddPermission("printers:print")
+	return info, nil
+}
+
+func TestBulkResolvesOnce(t *testing.T) {
+	cr := &countingRealm{}
+	msm := newSecurityManager()
+	msm.SetRealm(cr)
+
+	principals := []interface{}{"foo"}
+
+	assert.Equal(t, []bool{true, false, true}, msm.IsPermittedEach(principals, "printers:print", "printers:manage", "printers:print:hp"))
+	assert.Equal(t, 1, cr.calls)
+
+	assert.Equal(t, []bool{true, false}, msm.HasRoles(principals, "printer", "admin"))
+	assert.Equal(t, 2, cr.calls)
+
+	// A realm which is also an Authorizer answers by itself
+	ir, err := realm.NewIni("ini", strings.NewReader(ini))
+	require.NoError(t, err)
+
+	ar := &countingIniRealm{IniRealm: ir}
+	msm.SetRealm(ar)
+	msm.SetAuthorizationPolicy(&AllGrantPolicy{})
+
+	assert.Equal(t, []bool{true, false}, msm.IsPermittedEach([]interface{}{"foo"}, "write:foo", "read:foo"))
+	assert.Equal(t, []bool{true, false}, msm.HasRoles([]interface{}{"foo"}, "manager", "admin"))
+	assert.False(t, msm.IsPermitted([]interface{}{"nobody"}, "write:foo"), "An unknown account abstains")
+	assert.Equal(t, 0, ar.calls)
+}
+
+// An IniRealm which counts how often its AuthorizationInfo is asked from outside.
+type countingIniRealm struct {
+	*realm.IniRealm
+	calls int
+}
+
+func (r *countingIniRealm) AuthorizationInfo(principals []interface{}) (authz.AuthorizationInfo, error) {
+	r.calls++
+	return r.IniRealm.AuthorizationInfo(principals)
+}
+
+func TestAuthorizerBuiltOnce(t *testing.T) {
+	msm := newSecurityManager()
+	msm.SetRealm(&countingRealm{})
+
+	a := msm.authorizer()
+	assert.True(t, a == msm.authorizer())
+
+	policy := &DenyOverridesPolicy{}
+	msm.SetAuthorizationPolicy(policy)
+	b := msm.authorizer()
+	assert.False(t, a == b, "A new policy needs a new authorizer")
+	assert.Equal(t, policy, b.Policy)
+	assert.True(t, b == msm.authorizer())
+
+	msm.AddRealm(&countingRealm{})
+	c := msm.authorizer()
+	assert.False(t, b == c, "A new realm needs a new authorizer")
+	assert.Len(t, c.realms, 2)
+
+	// Settings which cannot be compared, such as functions, are reused all the same
+	msm.SetClock(authz.ClockFunc(time.Now))
+	msm.SetRolePermissionResolver(authz.RolePermissionResolverFunc(func(role string) []authz.Permission { return nil }))
+	d := msm.authorizer()
+	assert.False(t, c == d)
+	assert.NotNil(t, d.Clock)
+	assert.NotNil(t, d.RolePermissionResolver)
+	assert.True(t, d == msm.authorizer())
+}
+
+func TestBulkInvalidPermission(t *testing.T) {
+	msm := newSecurityManager()
+	msm.SetRealm(&countingRealm{})
+
+	principals := []interface{}{"foo"}
+
+	// An unparseable permission must be denied, not stored as a typed nil which panics later
+	assert.Equal(t, []bool{false, true}, msm.IsPermittedEach(principals, "", "printers:print"))
+	assert.False(t, msm.IsPermitted(principals, ""))
+	assert.False(t, msm.IsPermittedAll(principals, "printers:print", ""))
+	assert.True(t, msm.IsPermittedAny(principals, "", "printers:print"))
+}
+
+// An UpdatableCredentialsRealm which keeps the stored credentials in a map.
+type updatingRealm struct {
+	matcher credential.CredentialsMatcher
+	stored  map[string]string
+	updates int
+}
+
+func (r *updatingRealm) Name() string                                       { return "updating" }
+func (r *updatingRealm) Supports(token authc.AuthenticationToken) bool      { return true }
+func (r *updatingRealm) CredentialsMatcher() credential.CredentialsMatcher { return r.matcher }
+func (r *updatingRealm) AuthenticationInfo(token authc.AuthenticationToken) (authc.AuthenticationInfo, error) {
+	if pw, ok := r.stored[fmt.Sprint(token.Principal())]; ok {
+		return authc.NewAccount(token.Principal(), pw, r.Name()), nil
+	}
+	return nil, realm.ErrUnknownAccount
+}
+func (r *updatingRealm) UpdateCredentials(info authc.AuthenticationInfo, credentials string) error {
+	r.updates++
+	r.stored[fmt.Sprint(info.Principals()[0])] = credentials
+	return nil
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	old, err := credential.NewShiro1("SHA-256", 10).Encode([]byte("password"))
+	require.NoError(t, err)
+
+	r := &updatingRealm{
+		matcher: &credential.ModularCrypt{
+			Fallback: credential.NewPlain(),
+			Encoder:  credential.NewPBKDF2("SHA-256", 100),
+		},
+		stored: map[string]string{"foo": old, "bar": "password"},
+	}
+
+	msm := newSecurityManager()
+	msm.SetRealm(r)
+
+	_, err = msm.Authenticate(authc.NewToken("foo", "wrong"))
+	assert.Error(t, err)
+	assert.Equal(t, old, r.stored["foo"], "A failed login must not rehash")
+
+	for _, user := range []string{"foo", "bar"} {
+		_, err = msm.Authenticate(authc.NewToken(user, "password"))
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(r.stored[user], credential.PBKDF2Prefix), r.stored[user])
+
+		_, err = msm.Authenticate(authc.NewToken(user, "password"))
+		assert.NoError(t, err, "The new hash must work")
+	}
+
+	assert.Equal(t, 2, r.updates, "An up-to-date hash is not rehashed")
+}
+
+// Counts the passwords it encodes
+type countingEncoder struct {
+	credential.PasswordEncoder
+	encodes int
+}
+
+func (e *countingEncoder) Encode(password []byte) (string, error) {
+	e.encodes++
+	return e.PasswordEncoder.Encode(password)
+}
+
+func TestRehashNotUpdatable(t *testing.T) {
+	encoder := &countingEncoder{PasswordEncoder: &credential.ModularCrypt{
+		Fallback: credential.NewPlain(),
+		Encoder:  credential.NewPBKDF2("SHA-256", 100),
+	}}
+
+	ini, err := realm.NewIni("ini", strings.NewReader("[users]\nfoo = password\n"), realm.WithCredentialsMatcher(encoder))
+	require.NoError(t, err)
+
+	cr := realm.NewCaching(ini, cache.NewMemoryCache())
+	assert.False(t, realm.CanUpdateCredentials(cr))
+
+	msm := newSecurityManager()
+	msm.SetRealm(cr)
+
+	_, err = msm.Authenticate(authc.NewToken("foo", "password"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, encoder.encodes, "A realm which cannot update must not cause a rehash")
+
+	updating := &updatingRealm{matcher: credential.NewPlain(), stored: map[string]string{}}
+	assert.True(t, realm.CanUpdateCredentials(updating))
+}
+
+func TestMemoizeDecisions(t *testing.T) {
+	cr := &countingRealm{}
+	msm := newSecurityManager()
+	msm.SetRealm(cr)
+	msm.SetSessionManager(session.NewMemory(30 * time.Second))
+	msm.MemoizeDecisions = true
+
+	subject, _ := msm.CreateSubject(&SubjectContext{
+		Authenticated:  true,
+		Principals:     []interface{}{"foo"},
+		CreateSessions: true,
+	})
+
+	assert.True(t, subject.IsPermitted("printers:print"))
+	assert.True(t, subject.IsPermitted("printers:print"))
+	assert.True(t, subject.HasRole("printer"))
+	assert.True(t, subject.HasRole("printer"))
+	assert.Equal(t, 3, cr.calls, "One call to find out if the answers may change with time")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		subject.IsPermitted("printers:print")
+	})
+	assert.Equal(t, 0.0, allocs)
+
+	// Changing the identity forgets the decisions
+	require.NoError(t, subject.RunAs([]interface{}{"bar"}))
+	assert.True(t, subject.IsPermitted("printers:print"))
+	assert.Equal(t, 5, cr.calls)
+
+	_, err := subject.ReleaseRunAs()
+	require.NoError(t, err)
+	assert.True(t, subject.IsPermitted("printers:print"))
+	assert.Equal(t, 7, cr.calls)
+
+	subject.Logout()
+	assert.False(t, subject.IsPermitted("printers:print"))
+	assert.False(t, subject.HasRole("printer"))
+
+	// Memoization can also be asked for a single Subject
+	msm.MemoizeDecisions = false
+
+	memoized, _ := msm.CreateSubject(&SubjectContext{
+		Principals:       []interface{}{"foo"},
+		MemoizeDecisions: true,
+	})
+	memoized.IsPermitted("printers:print")
+	memoized.IsPermitted("printers:print")
+	assert.Equal(t, 9, cr.calls)
+
+	unmemoized, _ := msm.CreateSubject(&SubjectContext{Principals: []interface{}{"foo"}})
+	unmemoized.IsPermitted("printers:print")
+	unmemoized.IsPermitted("printers:print")
+	assert.Equal(t, 11, cr.calls)
+}
+
+func TestMemoizeTimeBounded(t *testing.T) {
+	src := `
+  [users]
+  foo = password, staff, oncall until 2025-12-31
+  bar = password, staff
+
+  [roles]
+  staff = wiki:read, wiki:edit until 2025-06-30
+  oncall = servers:restart
+`
+	r, err := realm.NewIni("ini", strings.NewReader(src))
+	require.NoError(t, err)
+
+	now := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	msm := newSecurityManager()
+	msm.SetRealm(r)
+	msm.SetClock(authz.ClockFunc(func() time.Time { return now }))
+	msm.MemoizeDecisions = true
+
+	foo, _ := msm.CreateSubject(&SubjectContext{Principals: []interface{}{"foo"}})
+
+	assert.True(t, foo.HasRole("oncall"))
+	assert.True(t, foo.IsPermitted("wiki:edit"))
+
+	now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.False(t, foo.HasRole("oncall"), "Time-bounded decisions must not be remembered")
+	assert.False(t, foo.IsPermitted("wiki:edit"))
+	assert.True(t, foo.IsPermitted("wiki:read"))
+
+	assert.True(t, r.TimeBounded([]interface{}{"bar"}), "Through the staff role")
+	assert.False(t, r.TimeBounded([]interface{}{"nobody"}))
+}
+
+func TestSetClock(t *testing.T) {
+	src := `
+  [users]
+  foo = password, oncall from 2030-01-01
+`
+	r1, err := realm.NewIni("first", strings.NewReader(src))
+	require.NoError(t, err)
+	r2, err := realm.NewIni("second", strings.NewReader(src))
+	require.NoError(t, err)
+
+	future := authz.ClockFunc(func() time.Time { return time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC) })
+	foo := []interface{}{"foo"}
+
+	msm := newSecurityManager()
+	msm.SetRealm(r1)
+	assert.False(t, msm.HasRole(foo, "oncall"))
+
+	msm.SetClock(future)
+	assert.True(t, msm.HasRole(foo, "oncall"), "The realms get the Clock")
+
+	msm.SetAuthorizationPolicy(&AllGrantPolicy{})
+	msm.AddRealm(r2)
+	assert.True(t, msm.HasRole(foo, "oncall"), "And so do the realms added later")
+}
+
+func TestRolePermissionResolver(t *testing.T) {
+	cr := &countingRealm{}
+	msm := newSecurityManager()
+	msm.SetRealm(cr)
+
+	principals := []interface{}{"foo"}
+
+	assert.False(t, msm.IsPermitted(principals, "printers:manage"))
+	assert.False(t, msm.IsPermitted(principals, ""))
+
+	// Use the roles from an IniRealm for the permissions of the "printer" role
+	roles, _ := realm.NewIni("roles", strings.NewReader(`
+  [roles]
+  printer = printers:manage
+`))
+	msm.SetRolePermissionResolver(roles)
+
+	assert.True(t, msm.IsPermitted(principals, "printers:manage"))
+	assert.True(t, msm.IsPermitted(principals, "printers:print"))
+	assert.Equal(t, []bool{true, false}, msm.IsPermittedEach(principals, "printers:manage:hp", "scanners:manage"))
+}
+
+func TestIsPermittedOn(t *testing.T) {
+	msm := newSecurityManager()
+	r, err := realm.NewIni("ini", strings.NewReader(`
+  [users]
+  foo = password, author
+
+  [roles]
+  author = documents:edit if resource.owner == subject.id
+`))
+	require.NoError(t, err)
+	msm.SetRealm(r)
+
+	subject, _ := msm.CreateSubject(&SubjectContext{
+		Authenticated: true,
+		Principals:    []interface{}{"foo"},
+	})
+
+	assert.True(t, subject.IsPermittedOn("documents:edit", map[string]interface{}{"owner": "foo"}))
+	assert.False(t, subject.IsPermittedOn("documents:edit", map[string]interface{}{"owner": "bar"}))
+	assert.False(t, subject.IsPermitted("documents:edit"))
+
+	anonymous, _ := msm.CreateSubject(&SubjectContext{})
+	assert.False(t, anonymous.IsPermittedOn("documents:edit", map[string]interface{}{"owner": "foo"}))
+}
+
 func TestCreateReady(t *testing.T) {
 	var principals []interface{}
 	principals = append(principals, "hello")
@@ -181,6 +586,105 @@ func TestRunAs(t *testing.T) {
 
 }
 
+func TestContext(t *testing.T) {
+	assert.Nil(t, FromContext(context.Background()))
+
+	subject, _ := sm.CreateSubject(&SubjectContext{})
+	ctx := NewContext(context.Background(), subject)
+
+	assert.Equal(t, subject, FromContext(ctx))
+
+	// Get() must find the Subject even after the request has been copied
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req = req.WithContext(ctx).WithContext(context.WithValue(ctx, "other", 1))
+
+	assert.Equal(t, subject, Get(req, httptest.NewRecorder()))
+}
+
+func TestMiddleware(t *testing.T) {
+	var first, second Subject
+
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first = FromContext(r.Context())
+		second = Get(r.WithContext(context.WithValue(r.Context(), "other", 1)), w)
+	}))
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.NotNil(t, first)
+	assert.True(t, first == second, "Got a different Subject from Get()")
+	assert.Empty(t, subjects, "Middleware must not store Subjects globally")
+}
+
+func TestGetMemorySession(t *testing.T) {
+	r, _ := realm.NewIni("ini", strings.NewReader(ini))
+	Manager.SetRealm(r)
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	w := httptest.NewRecorder()
+
+	subject := Get(req, w)
+	require.NoError(t, subject.Login(authc.NewToken("foo", "password")))
+	Finish(req)
+
+	cookies := w.Result().Cookies()
+	require.NotEmpty(t, cookies)
+
+	// A new request with the session cookie gets the logged in Subject back
+	req, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+	req.AddCookie(cookies[0])
+
+	subject = Get(req, httptest.NewRecorder())
+	defer Finish(req)
+
+	assert.True(t, subject.IsAuthenticated())
+	assert.Equal(t, "foo", fmt.Sprint(subject.Principal()))
+}
+
+func TestLoginRenewsSession(t *testing.T) {
+	r, _ := realm.NewIni("ini", strings.NewReader(ini))
+	Manager.SetRealm(r)
+
+	// An anonymous visit gets a session, whose cookie an attacker could have planted
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	w := httptest.NewRecorder()
+
+	subject := Get(req, w)
+	subject.Session().Set("cart", "books")
+	before := subject.Session()
+	Finish(req)
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	req, _ = http.NewRequest("GET", "http://example.com/login", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+
+	subject = Get(req, w)
+	require.Equal(t, before.Id(), subject.Session().Id())
+	require.NoError(t, subject.Login(authc.NewToken("foo", "password")))
+	Finish(req)
+
+	after := subject.Session()
+
+	assert.NotEqual(t, before.Id(), after.Id())
+	assert.False(t, before.IsValid())
+	assert.Equal(t, "books", after.Get("cart"))
+
+	cookies = w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, after.Id(), cookies[0].Value)
+
+	// The old cookie no longer gets the logged in Subject
+	req, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+	req.AddCookie(&http.Cookie{Name: session.DefaultSessionCookie, Value: before.Id()})
+
+	assert.False(t, Get(req, httptest.NewRecorder()).IsAuthenticated())
+	Finish(req)
+}
+
 func TestString(t *testing.T) {
 	subject, _ := sm.CreateSubject(&SubjectContext{
 		CreateSessions: true,
@@ -25,6 +25,10 @@ type SubjectContext struct {
 
 	// The list of Principals covered by this Subject.
 	Principals     []interface{}
+
+	// If true, the Subject remembers the answers to HasRole() and IsPermitted(), so that
+	// repeated checks do not ask the realms again.  See DefaultSecurityManager.MemoizeDecisions.
+	MemoizeDecisions bool
 }
 
 // Creates a new Session context from a Subject Context.